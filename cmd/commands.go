@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/users-api/infrastructure"
+	"gopkg.in/yaml.v2"
+)
+
+// command is a subcommand of the binary, it runs with the args after its name once the config
+// of the environment is read, except for serve and version.
+type command struct {
+	usage   string
+	summary string
+	run     func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"serve":   {usage: "serve", summary: "Serve the api, the default command", run: serveCommand},
+	"migrate": {usage: "migrate up | down [-steps n] | status", summary: "Apply, revert or list the schema migrations", run: migrateCommand},
+	"seed":    {usage: "seed [-file fixtures.yml] [-force]", summary: "Insert the fixtures of database.fixtures or the file", run: seedCommand},
+	"config":  {usage: "config print | validate", summary: "Print the config with its secrets redacted, or check it", run: configCommand},
+	"version": {usage: "version", summary: "Print the version and build info", run: versionCommand},
+}
+
+// errUsage is returned for invalid arguments, the usage of the command is printed after it.
+var errUsage = errors.New("invalid arguments")
+
+type cli struct {
+	env string
+	out io.Writer
+	err io.Writer
+}
+
+// run runs the command of the args, serve when there is none, and returns the exit code: 1
+// when it failed, 2 when the arguments are invalid.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("users-api", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	env := fs.String("E", "dev", "Execution environment")
+	fs.Usage = func() { usage(stderr, fs) }
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	name, args := "serve", fs.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %s\n\n", name)
+		usage(stderr, fs)
+		return 2
+	}
+
+	c := &cli{env: *env, out: stdout, err: stderr}
+	if name != "serve" && name != "version" {
+		if err := readConfiguration(*env); err != nil {
+			fmt.Fprintf(stderr, "can't read the config of %s: %v\n", *env, err)
+			return 1
+		}
+	}
+
+	if err := cmd.run(c, args); err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(stderr, "Usage: main [-E env] %s\n", cmd.usage)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer, fs *flag.FlagSet) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Usage: main [-E env] <command>\n\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	fs.PrintDefaults()
+}
+
+func serveCommand(c *cli, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	StartApp(c.env)
+	return nil
+}
+
+func versionCommand(c *cli, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	fmt.Fprintln(c.out, versionInfo())
+	return nil
+}
+
+func migrateCommand(c *cli, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	fs.SetOutput(c.err)
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() > 0 || *steps < 1 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "up", "down", "status":
+	default:
+		return errUsage
+	}
+
+	db, err := infrastructure.OpenDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := infrastructure.MigrateUp(db)
+		for _, migration := range applied {
+			fmt.Fprintf(c.out, "applied %d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(c.out, "the schema is up to date")
+		}
+		return err
+	case "down":
+		reverted, err := infrastructure.MigrateDown(db, *steps)
+		for _, migration := range reverted {
+			fmt.Fprintf(c.out, "reverted %d %s\n", migration.Version, migration.Name)
+		}
+		return err
+	default:
+		statuses, err := infrastructure.MigrationStatuses(db)
+		if err != nil {
+			return err
+		}
+		printMigrations(c.out, statuses)
+		return nil
+	}
+}
+
+func printMigrations(w io.Writer, statuses []infrastructure.MigrationStatus) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED_AT")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	tw.Flush()
+}
+
+func seedCommand(c *cli, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fs.SetOutput(c.err)
+	file := fs.String("file", viper.GetString("database.fixtures"), "YAML or JSON file with the fixtures")
+	force := fs.Bool("force", false, "seed the live environment")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 || *file == "" {
+		return errUsage
+	}
+
+	if viper.GetString("env") == "live" && !*force {
+		return errors.New("refusing to seed the live environment, use -force")
+	}
+
+	db, err := infrastructure.OpenDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := infrastructure.Seed(db, *file)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "%d users inserted from %s\n", count, *file)
+	return nil
+}
+
+func configCommand(c *cli, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "print":
+		data, err := yaml.Marshal(redact(viper.AllSettings()))
+		if err != nil {
+			return err
+		}
+		_, err = c.out.Write(data)
+		return err
+	case "validate":
+		problems := validateConfig()
+		if len(problems) == 0 {
+			fmt.Fprintf(c.out, "the config of %s is valid\n", c.env)
+			return nil
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(c.err, problem)
+		}
+		return fmt.Errorf("the config of %s has %d problems", c.env, len(problems))
+	default:
+		return errUsage
+	}
+}
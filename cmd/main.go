@@ -1,23 +1,33 @@
 package main
 
 import (
-	"flag"
+	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/users-api/cmd/server"
+	"github.com/users-api/infrastructure"
+	"github.com/users-api/pkg/apikey"
 	user "github.com/users-api/pkg/user"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 )
 
 func main() {
-	StartApp()
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
 }
 
-func StartApp() {
+// StartApp serves the api with the config of the environment until an interrupt.
+func StartApp(env string) {
 	initLog()
-	readConfiguration()
+	logrus.Infof("Starting user api in %s environment ...", env)
+	if err := readConfiguration(env); err != nil {
+		logrus.Errorf("error reading configuration from viper: %v", err)
+	}
+	if problems := validateConfig(); len(problems) > 0 {
+		logrus.Fatalf("invalid configuration of %s: %s", env, strings.Join(problems, "; "))
+	}
 	startWebServer()
 }
 
@@ -28,18 +38,36 @@ func initLog() {
 
 func startWebServer() {
 
-	s := server.New(&server.Config{
-		Port: viper.GetInt("server.port"),
-	})
+	s := server.New(serverConfig())
 
-	s.AddRoute("/health", func(w http.ResponseWriter, r *http.Request) {
-		server.OK(w, r, map[string]interface{}{
-			"name":   viper.GetString("server.name"),
-			"status": "healthy",
-		})
-	}, http.MethodGet)
+	if err := s.CORS(corsConfig()); err != nil {
+		logrus.Fatalf("error configuring cors: %v", err)
+	}
+
+	// a single pool, migrated once, is shared by the services and the idempotency store
+	db := infrastructure.ConnectDatabase()
+	apikeyService := apikey.NewService(db)
+
+	// the ip limit runs before the authentication so that wrong credentials are counted
+	rateLimitStore := server.NewMemoryRateLimitStore()
+	s.Use(server.RequestID)
+	s.Use(server.IPRateLimiter(rateLimitStore, server.RateLimit{
+		Rate:  viper.GetFloat64("ratelimit.ip.rate"),
+		Burst: viper.GetInt("ratelimit.ip.burst"),
+	}))
+	s.Use(s.Authentication(newJWTAuthenticator(), apikey.NewAuthenticator(apikeyService)))
+	s.Use(s.RateLimiter(rateLimitStore, rateLimits()))
+	s.Use(s.Tenancy(server.TenancyConfig{
+		DefaultTenant:     infrastructure.DefaultTenant(),
+		AnyTenantSubjects: viper.GetStringSlice("tenancy.any_tenant_subjects"),
+	}))
+	if viper.GetBool("validation.requests") {
+		s.Use(s.Validation(server.ValidationConfig{Responses: viper.GetBool("validation.responses")}))
+	}
+	s.Use(server.Idempotency(newIdempotencyStore(db), viper.GetDuration("idempotency.ttl")))
 
-	user.RegisterRoutes(s)
+	registerRoutes(s, user.NewService(db), apikeyService)
+	deprecateVersions(s)
 
 	logrus.Info("starting http listener ...")
 	go func() {
@@ -54,17 +82,166 @@ func startWebServer() {
 
 }
 
-func readConfiguration() {
+// registerRoutes adds every route of the api, each one with its doc.
+func registerRoutes(s *server.Server, userService user.IService, apikeyService apikey.IService) {
+	s.AddRoute("/health", func(w http.ResponseWriter, r *http.Request) {
+		server.OK(w, r, map[string]interface{}{
+			"name":    viper.GetString("server.name"),
+			"status":  "healthy",
+			"version": version,
+		})
+	}, http.MethodGet).Name("health").Public().Doc(server.Doc{
+		Summary:   "Health of the api",
+		Tags:      []string{"status"},
+		Responses: map[int]interface{}{http.StatusOK: map[string]string{}},
+	})
 
-	//Util for several environments
-	env := flag.String("E", "dev", "Execution environment")
-	flag.Parse()
-	logrus.Infof("Starting user api in %s environment ...", *env)
+	s.AddRoute("/versions", func(w http.ResponseWriter, r *http.Request) {
+		server.OK(w, r, server.VersionList{Data: s.Versions()})
+	}, http.MethodGet).Name("versions").Public().Doc(server.Doc{
+		Summary:   "Versions of the api",
+		Tags:      []string{"status"},
+		Responses: map[int]interface{}{http.StatusOK: server.VersionList{}},
+	})
 
-	viper.AddConfigPath("./cmd/config")
-	viper.SetConfigName("env_" + *env)
+	user.RegisterRoutes(s, userService)
+	apikey.RegisterRoutes(s, apikeyService)
 
-	if err := viper.ReadInConfig(); err != nil {
-		logrus.Errorf("error reading configuration from viper: %v", err)
+	s.AddDocs(server.OpenAPIInfo{
+		Title:       "users-api",
+		Description: "Users, their history and locations.",
+		Version:     "1",
+	})
+}
+
+func newJWTAuthenticator() server.Authenticator {
+	authenticator, err := server.NewJWTAuthenticator(jwtConfig())
+	if err != nil {
+		logrus.Fatalf("error configuring jwt authentication: %v", err)
 	}
+
+	return authenticator
+}
+
+func serverConfig() *server.Config {
+	return &server.Config{
+		Port: viper.GetInt("server.port"),
+		TLS: server.TLSConfig{
+			CertFile:           viper.GetString("server.tls.cert_file"),
+			KeyFile:            viper.GetString("server.tls.key_file"),
+			ClientCAFile:       viper.GetString("server.tls.client_ca_file"),
+			ClientCertRequired: viper.GetBool("server.tls.client_cert_required"),
+			ReloadInterval:     viper.GetDuration("server.tls.reload_interval"),
+		},
+		ReadTimeout:       viper.GetDuration("server.read_timeout"),
+		ReadHeaderTimeout: viper.GetDuration("server.read_header_timeout"),
+		WriteTimeout:      viper.GetDuration("server.write_timeout"),
+		IdleTimeout:       viper.GetDuration("server.idle_timeout"),
+		MaxHeaderBytes:    viper.GetInt("server.max_header_bytes"),
+		HandlerTimeout:    viper.GetDuration("server.handler_timeout"),
+		MaxBodyBytes:      viper.GetInt64("server.max_body_bytes"),
+		Routes:            routeConfigs(),
+	}
+}
+
+func corsConfig() server.CORSConfig {
+	return server.CORSConfig{
+		AllowedOrigins:   viper.GetStringSlice("cors.allowed_origins"),
+		AllowedMethods:   viper.GetStringSlice("cors.allowed_methods"),
+		AllowedHeaders:   viper.GetStringSlice("cors.allowed_headers"),
+		ExposedHeaders:   viper.GetStringSlice("cors.exposed_headers"),
+		AllowCredentials: viper.GetBool("cors.allow_credentials"),
+		MaxAge:           viper.GetDuration("cors.max_age"),
+	}
+}
+
+func jwtConfig() server.JWTConfig {
+	return server.JWTConfig{
+		Secret:    viper.GetString("auth.jwt.secret"),
+		PublicKey: viper.GetString("auth.jwt.public_key"),
+		JWKSFile:  viper.GetString("auth.jwt.jwks_file"),
+		Issuer:    viper.GetString("auth.jwt.issuer"),
+		Audience:  viper.GetString("auth.jwt.audience"),
+		Leeway:    viper.GetDuration("auth.jwt.leeway"),
+	}
+}
+
+// deprecateVersions reads the versions.<version>.deprecated_at and sunset_at dates.
+func deprecateVersions(s *server.Server) {
+	for _, version := range s.Versions() {
+		key := "versions." + version.Version
+		if at := viper.GetTime(key + ".deprecated_at"); !at.IsZero() {
+			s.APIVersion(version.Version).Deprecated(at)
+		}
+		if at := viper.GetTime(key + ".sunset_at"); !at.IsZero() {
+			s.APIVersion(version.Version).Sunset(at)
+		}
+	}
+}
+
+// rateLimits reads ratelimit.default and the ratelimit.routes.<route name> overrides.
+func rateLimits() server.RateLimits {
+	limits := server.RateLimits{
+		Default: server.RateLimit{
+			Rate:  viper.GetFloat64("ratelimit.default.rate"),
+			Burst: viper.GetInt("ratelimit.default.burst"),
+		},
+		Routes: make(map[string]server.RateLimit),
+	}
+
+	for _, key := range viper.AllKeys() {
+		if !strings.HasPrefix(key, "ratelimit.routes.") || !strings.HasSuffix(key, ".rate") {
+			continue
+		}
+		route := strings.TrimSuffix(key, ".rate")
+		limits.Routes[strings.TrimPrefix(route, "ratelimit.routes.")] = server.RateLimit{
+			Rate:  viper.GetFloat64(route + ".rate"),
+			Burst: viper.GetInt(route + ".burst"),
+		}
+	}
+
+	return limits
+}
+
+// routeConfigs reads the server.routes.<route name> overrides of the timeout and body limit.
+func routeConfigs() map[string]server.RouteConfig {
+	routes := make(map[string]server.RouteConfig)
+
+	for _, key := range viper.AllKeys() {
+		if !strings.HasPrefix(key, "server.routes.") {
+			continue
+		}
+		route := key[:strings.LastIndex(key, ".")]
+		routes[strings.TrimPrefix(route, "server.routes.")] = server.RouteConfig{
+			Timeout:      viper.GetDuration(route + ".timeout"),
+			MaxBodyBytes: viper.GetInt64(route + ".max_body_bytes"),
+		}
+	}
+
+	return routes
+}
+
+func newIdempotencyStore(db *sqlx.DB) server.IdempotencyStore {
+	if viper.GetString("idempotency.store") == "memory" {
+		return server.NewMemoryIdempotencyStore()
+	}
+
+	return server.NewMySQLIdempotencyStore(db)
+}
+
+// readConfiguration reads cmd/config/env_<env>.yml.
+func readConfiguration(env string) error {
+	viper.AddConfigPath("./cmd/config")
+	viper.SetConfigName("env_" + env)
+	bindEnv()
+
+	return viper.ReadInConfig()
+}
+
+// bindEnv lets the environment override any key of the config, e.g. USERS_API_AUTH_JWT_SECRET
+// sets auth.jwt.secret; the secrets of live are only set this way.
+func bindEnv() {
+	viper.SetEnvPrefix("users_api")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/users-api/cmd/server"
+	"github.com/users-api/infrastructure"
+)
+
+// TestRoutesDocumented fails when a route is added without its doc, so that the OpenAPI
+// document can't drift from the routes.
+func TestRoutesDocumented(t *testing.T) {
+	s := server.New(&server.Config{})
+	registerRoutes(s, nil, nil)
+
+	assert.Empty(t, s.Undocumented(), "routes without doc, add one with Doc()")
+
+	doc := s.OpenAPI(server.OpenAPIInfo{Title: "users-api", Version: "1"})
+	_, err := json.Marshal(doc)
+	assert.Nil(t, err)
+	assert.Contains(t, doc.Paths, "/v1/users/{id}")
+	assert.Contains(t, doc.Components.Schemas, "User")
+	assert.Contains(t, doc.Components.Schemas, "Error")
+}
+
+func TestRedact(t *testing.T) {
+	settings := redact(map[string]interface{}{
+		"database": map[string]interface{}{"user": "root", "pass": "root"},
+		"auth": map[string]interface{}{
+			"jwt": map[string]interface{}{"secret": "s3cr3t", "public_key": "/keys/jwt.pem", "issuer": ""},
+		},
+		"pagination": map[string]interface{}{"cursor_secret": "c", "exact_count_limit": 1000},
+		"tenants": map[string]interface{}{
+			"acme": map[string]interface{}{"clients": map[string]interface{}{"map": map[string]interface{}{"token": "t"}}},
+		},
+		"server": map[string]interface{}{"tls": map[string]interface{}{"key_file": "/tls/tls.key", "api_key": ""}},
+	})
+
+	assert.Equal(t, settings["database"], map[string]interface{}{"user": "root", "pass": redacted})
+	assert.Equal(t, settings["auth"], map[string]interface{}{
+		"jwt": map[string]interface{}{"secret": redacted, "public_key": "/keys/jwt.pem", "issuer": ""},
+	})
+	assert.Equal(t, settings["pagination"], map[string]interface{}{"cursor_secret": redacted, "exact_count_limit": 1000})
+	assert.Equal(t, settings["tenants"].(map[string]interface{})["acme"].(map[string]interface{})["clients"],
+		map[string]interface{}{"map": map[string]interface{}{"token": redacted}})
+	assert.Equal(t, settings["server"], map[string]interface{}{"tls": map[string]interface{}{"key_file": "/tls/tls.key", "api_key": ""}})
+}
+
+func TestValidateConfig(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.AddConfigPath("./config")
+	viper.SetConfigName("env_dev")
+	assert.Nil(t, viper.ReadInConfig())
+	assert.Empty(t, validateConfig())
+
+	viper.Set("server.port", 0)
+	viper.Set("idempotency.ttl", "1 day")
+	viper.Set("database.host", "")
+	viper.Set("cors.allowed_origins", []string{"*"})
+	viper.Set("server.tls.client_cert_required", true)
+	viper.Set("versions", map[string]interface{}{"1": map[string]interface{}{"sunset_at": "2027-07-01"}})
+	viper.Set("auth.jwt.secret", "")
+	viper.Set("pagination.cursor_secret", "short")
+
+	assert.Equal(t, validateConfig(), []string{
+		"auth.jwt: jwt: no secret, public key or jwks file configured",
+		"cors: credentials can't be allowed for any origin",
+		"database.host: is mandatory",
+		"idempotency.ttl: 1 day is not a valid duration, e.g. 30s",
+		"pagination.cursor_secret: must have at least 32 characters",
+		"server.port: 0 is not a valid port",
+		"server.tls.client_cert_required: needs a client_ca_file",
+		"versions.1.sunset_at: 2027-07-01 is not an RFC 3339 time",
+	})
+}
+
+func TestValidateConfigLive(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.AddConfigPath("./config")
+	viper.SetConfigName("env_live")
+	bindEnv()
+	assert.Nil(t, viper.ReadInConfig())
+	// the certificates are only on the live hosts
+	for _, key := range []string{"server.tls.cert_file", "server.tls.key_file", "server.tls.client_ca_file"} {
+		viper.Set(key, "")
+	}
+
+	// the secrets of live are not in the repo
+	assert.Equal(t, validateConfig(), []string{
+		"auth.jwt: jwt: no secret, public key or jwks file configured",
+		"pagination.cursor_secret: is mandatory",
+	})
+
+	os.Setenv("USERS_API_AUTH_JWT_SECRET", strings.Repeat("s", 32))
+	os.Setenv("USERS_API_PAGINATION_CURSOR_SECRET", strings.Repeat("c", 32))
+	defer os.Unsetenv("USERS_API_AUTH_JWT_SECRET")
+	defer os.Unsetenv("USERS_API_PAGINATION_CURSOR_SECRET")
+	assert.Empty(t, validateConfig())
+	assert.Equal(t, jwtConfig().Secret, strings.Repeat("s", 32))
+}
+
+func TestFixtures(t *testing.T) {
+	fixtures, err := infrastructure.ReadFixtures("./config/fixtures.yml")
+	assert.Nil(t, err)
+	assert.Equal(t, len(fixtures.Users), 8)
+	assert.Equal(t, fixtures.Users[7], infrastructure.UserFixture{TenantId: "default", Name: "Jhon", Address: "5th avenue", Dob: "1991-01-10"})
+}
+
+func TestRun(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	assert.Equal(t, run([]string{"version"}, &stdout, &stderr), 0)
+	assert.Contains(t, stdout.String(), "users-api dev")
+
+	stderr.Reset()
+	assert.Equal(t, run([]string{"deploy"}, &stdout, &stderr), 2)
+	assert.Contains(t, stderr.String(), "unknown command deploy")
+
+	stderr.Reset()
+	assert.Equal(t, run([]string{"-E", "missing", "config", "print"}, &stdout, &stderr), 1)
+	assert.Contains(t, stderr.String(), "can't read the config of missing")
+}
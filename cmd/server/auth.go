@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ErrorCodeUnauthorized      string = "UNAUTHORIZED"
+	ErrorCodeInsufficientScope string = "INSUFFICIENT_SCOPE"
+
+	claimsKey contextKey = "claims"
+
+	realm string = "users-api"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request has none of its credentials,
+// so that the next one is tried.
+var ErrNoCredentials = errors.New("the request has no credentials")
+
+// Claims are the verified attributes of the caller.
+type Claims map[string]interface{}
+
+// Subject returns the sub claim.
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Scopes returns the scopes granted to the caller, from the space separated scope claim
+// (RFC 8693) or the scp array.
+func (c Claims) Scopes() []string {
+	if scope, ok := c["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+
+	scopes := make([]string, 0)
+	if scp, ok := c["scp"].([]interface{}); ok {
+		for _, item := range scp {
+			if scope, ok := item.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+// HasScope checks whether the caller was granted the scope.
+func (c Claims) HasScope(scope string) bool {
+	for _, granted := range c.Scopes() {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Claims) missingScopes(scopes []string) []string {
+	missing := make([]string, 0)
+	for _, scope := range scopes {
+		if !c.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+type Authenticator interface {
+	// Scheme is the authentication scheme of the WWW-Authenticate challenge.
+	Scheme() string
+	// Authenticate returns the claims of the caller, ErrNoCredentials when the request has
+	// no credentials for the authenticator or an AuthError when they are invalid.
+	Authenticate(r *http.Request) (Claims, error)
+}
+
+// AuthError is an authentication failure of the given scheme.
+type AuthError struct {
+	Scheme  string
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// Authentication rejects the requests to non public routes that none of the authenticators
+// accepts with a 401 and a WWW-Authenticate challenge, and the ones whose caller lacks a scope
+// of the route with a 403. The claims of the caller are put in the request context and its
+// subject is the actor of the request.
+func (s *Server) Authentication(authenticators ...Authenticator) mux.MiddlewareFunc {
+	s.authenticators = append(s.authenticators, authenticators...)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := s.currentRoute(r)
+			if route != nil && route.public {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			for _, authenticator := range authenticators {
+				claims, err := authenticator.Authenticate(r)
+				if err == ErrNoCredentials {
+					continue
+				}
+				if _, ok := err.(*AuthError); ok {
+					unauthorized(w, r, authenticators, err)
+					return
+				}
+				if err != nil {
+					InternalServerError(w, r, err)
+					return
+				}
+
+				if route != nil {
+					if missing := claims.missingScopes(route.scopes); len(missing) > 0 {
+						insufficientScope(w, r, authenticator, route.scopes, missing)
+						return
+					}
+				}
+
+				r = SetActor(r.WithContext(WithClaims(r.Context(), claims)), claims.Subject())
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			unauthorized(w, r, authenticators, ErrNoCredentials)
+		})
+	}
+}
+
+// WithClaims returns the context with the claims of the caller, for work done outside of a request.
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// GetClaims returns the claims of the authenticated caller, nil for public routes.
+func GetClaims(ctx context.Context) Claims {
+	claims, _ := ctx.Value(claimsKey).(Claims)
+	return claims
+}
+
+// unauthorized challenges with every scheme, the one that failed carries the error (RFC 6750).
+func unauthorized(w http.ResponseWriter, r *http.Request, authenticators []Authenticator, err error) {
+	failed := ""
+	if authErr, ok := err.(*AuthError); ok {
+		failed = authErr.Scheme
+	}
+
+	for _, authenticator := range authenticators {
+		challenge := fmt.Sprintf(`%s realm="%s"`, authenticator.Scheme(), realm)
+		if authenticator.Scheme() == failed {
+			challenge += fmt.Sprintf(`, error="invalid_token", error_description="%s"`, err.Error())
+		}
+		w.Header().Add("WWW-Authenticate", challenge)
+	}
+
+	Render(w, r, &errorResponse{
+		Code:     ErrorCodeUnauthorized,
+		Messages: []string{err.Error()},
+	}, http.StatusUnauthorized)
+}
+
+// insufficientScope challenges with the scopes of the route (RFC 6750).
+func insufficientScope(w http.ResponseWriter, r *http.Request, authenticator Authenticator, scopes []string, missing []string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s realm="%s", error="insufficient_scope", scope="%s"`,
+		authenticator.Scheme(), realm, strings.Join(scopes, " ")))
+
+	Forbidden(w, r, ErrorCodeInsufficientScope, fmt.Sprintf("the %s scope is required", strings.Join(missing, ", ")))
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthentication_Scopes(t *testing.T) {
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Secret: "secret"})
+	assert.Nil(t, err)
+
+	s := New(&Config{Port: 8080})
+	s.Use(s.Authentication(authenticator))
+	s.AddRoute("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, nil)
+	}, http.MethodGet).Scopes("users:read")
+	s.AddRoute("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, nil)
+	}, http.MethodDelete).Scopes("users:delete")
+
+	send := func(method string, claims Claims) *httptest.ResponseRecorder {
+		claims["exp"] = time.Now().Unix() + 60
+		req := httptest.NewRequest(method, "/users/1", nil)
+		req.Header.Set("Authorization", "Bearer "+signHS256("secret", claims))
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	dashboard := Claims{"sub": "dashboard", "scope": "users:read"}
+	assert.Equal(t, send(http.MethodGet, dashboard).Code, http.StatusOK)
+
+	forbidden := send(http.MethodDelete, dashboard)
+	assert.Equal(t, forbidden.Code, http.StatusForbidden)
+	assert.Contains(t, forbidden.Body.String(), ErrorCodeInsufficientScope)
+	assert.Contains(t, forbidden.Header().Get("WWW-Authenticate"), `error="insufficient_scope", scope="users:delete"`)
+
+	admin := Claims{"sub": "admin", "scp": []string{"users:read", "users:delete"}}
+	assert.Equal(t, send(http.MethodDelete, admin).Code, http.StatusOK)
+}
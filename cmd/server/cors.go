@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+const corsAnyOrigin = "*"
+
+var (
+	corsDefaultMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	corsDefaultHeaders = []string{"Authorization", "Content-Type", "Idempotency-Key", RequestIDHeader, TenantHeader, "X-API-Key"}
+	corsDefaultExposed = []string{"Location", "Link", RequestIDHeader, "Retry-After", "WWW-Authenticate",
+		"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Idempotent-Replayed"}
+)
+
+// CORSConfig holds the cross origin requests browsers may send. An origin is either "*", any
+// origin, an exact origin like https://admin.example.com or a wildcard subdomain like
+// https://*.example.com. Empty methods, headers or exposed headers take the defaults.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers cache a preflight, ten minutes at most
+	MaxAge time.Duration
+}
+
+// CORS answers the preflight requests of the routes and adds the CORS headers to the responses
+// of the allowed origins. It wraps the router instead of being a middleware because preflight
+// requests match no route, a preflight for a path or method without route gets the 404 or 405
+// of the router. Without origins it does nothing.
+func (s *Server) CORS(c CORSConfig) error {
+	if len(c.AllowedOrigins) == 0 {
+		return nil
+	}
+
+	options := []handlers.CORSOption{
+		handlers.AllowedMethods(orDefault(c.AllowedMethods, corsDefaultMethods)),
+		handlers.AllowedHeaders(orDefault(c.AllowedHeaders, corsDefaultHeaders)),
+		handlers.ExposedHeaders(orDefault(c.ExposedHeaders, corsDefaultExposed)),
+		handlers.MaxAge(int(c.MaxAge.Seconds())),
+		handlers.OptionStatusCode(http.StatusNoContent),
+	}
+
+	anyOrigin := false
+	for _, origin := range c.AllowedOrigins {
+		anyOrigin = anyOrigin || origin == corsAnyOrigin
+	}
+	switch {
+	case anyOrigin && c.AllowCredentials:
+		return errors.New("cors: credentials can't be allowed for any origin")
+	case anyOrigin:
+		options = append(options, handlers.AllowedOrigins([]string{corsAnyOrigin}))
+	default:
+		options = append(options, handlers.AllowedOriginValidator(func(origin string) bool {
+			return originAllowed(c.AllowedOrigins, origin)
+		}))
+	}
+	if c.AllowCredentials {
+		options = append(options, handlers.AllowCredentials())
+	}
+
+	cors := handlers.CORS(options...)(s.handler)
+	s.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !anyOrigin {
+			w.Header().Add("Vary", "Origin")
+		}
+		if isPreflight(r) && !s.matchesRoute(r, r.Header.Get("Access-Control-Request-Method")) {
+			s.router.ServeHTTP(w, r)
+			return
+		}
+		cors.ServeHTTP(w, r)
+	})
+
+	return nil
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// matchesRoute checks whether the request would match a route with the given method.
+func (s *Server) matchesRoute(r *http.Request, method string) bool {
+	req := r.Clone(r.Context())
+	req.Method = method
+
+	var match mux.RouteMatch
+	return s.router.Match(req, &match)
+}
+
+// originAllowed matches the origin against the exact origins and the wildcard subdomains,
+// https://*.example.com allows https://admin.example.com but not https://example.com.
+func originAllowed(allowed []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		i := strings.Index(pattern, "*.")
+		if i < 0 {
+			if pattern == origin {
+				return true
+			}
+			continue
+		}
+
+		prefix, suffix := pattern[:i], pattern[i+1:]
+		if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) ||
+			len(origin) <= len(prefix)+len(suffix) {
+			continue
+		}
+		if subdomain := origin[len(prefix) : len(origin)-len(suffix)]; isHostname(subdomain) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHostname(host string) bool {
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return !strings.HasPrefix(host, ".")
+}
+
+func orDefault(values []string, defaults []string) []string {
+	if len(values) == 0 {
+		return defaults
+	}
+	return values
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	s := New(&Config{Port: 8080})
+	s.AddRoute("/v1/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, map[string]string{})
+	}, http.MethodGet, http.MethodPut)
+
+	err := s.CORS(CORSConfig{
+		AllowedOrigins:   []string{"https://admin.example.com", "https://*.example.org"},
+		AllowCredentials: true,
+		MaxAge:           5 * time.Minute,
+	})
+	assert.Nil(t, err)
+
+	preflight := func(origin string, path string, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", "authorization, x-tenant-id")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	allowed := preflight("https://admin.example.com", "/v1/users/1", http.MethodPut)
+	assert.Equal(t, allowed.Code, http.StatusNoContent)
+	assert.Equal(t, allowed.Header().Get("Access-Control-Allow-Origin"), "https://admin.example.com")
+	assert.Equal(t, allowed.Header().Get("Access-Control-Allow-Methods"), http.MethodPut)
+	assert.Equal(t, allowed.Header().Get("Access-Control-Allow-Headers"), "Authorization,X-Tenant-Id")
+	assert.Equal(t, allowed.Header().Get("Access-Control-Allow-Credentials"), "true")
+	assert.Equal(t, allowed.Header().Get("Access-Control-Max-Age"), "300")
+
+	subdomain := preflight("https://eu.admin.example.org", "/v1/users/1", http.MethodGet)
+	assert.Equal(t, subdomain.Header().Get("Access-Control-Allow-Origin"), "https://eu.admin.example.org")
+
+	for _, origin := range []string{"https://example.org", "http://admin.example.org", "https://evil.com"} {
+		rejected := preflight(origin, "/v1/users/1", http.MethodGet)
+		assert.Empty(t, rejected.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+
+	assert.Equal(t, preflight("https://admin.example.com", "/v1/users/1", http.MethodDelete).Code, http.StatusMethodNotAllowed)
+	assert.Equal(t, preflight("https://admin.example.com", "/v1/unknown", http.MethodGet).Code, http.StatusNotFound)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/1", nil)
+	req.Header.Set("Origin", "https://admin.example.com")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Header().Get("Access-Control-Allow-Origin"), "https://admin.example.com")
+	assert.Contains(t, rec.Header().Get("Access-Control-Expose-Headers"), "X-Request-Id")
+	assert.Equal(t, rec.Header().Get("Vary"), "Origin")
+
+	assert.NotNil(t, New(&Config{}).CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}))
+}
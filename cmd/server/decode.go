@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const ErrorCodeUnsupportedMediaType string = "UNSUPPORTED_MEDIA_TYPE"
+
+// UnsupportedMediaTypeError is returned when decoding a body that is not JSON.
+type UnsupportedMediaTypeError struct {
+	ContentType string
+}
+
+func (e *UnsupportedMediaTypeError) Error() string {
+	if e.ContentType == "" {
+		return "the Content-Type header is required, use application/json"
+	}
+	return fmt.Sprintf("%s is not supported, use application/json", e.ContentType)
+}
+
+// DecodeJSON decodes the body of the request into v. The Content-Type must be application/json
+// or a +json type, and the body a single JSON value without fields v doesn't have.
+func DecodeJSON(r *http.Request, v interface{}) error {
+	if err := checkContentType(r); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		if err == io.EOF {
+			return errors.New("the body is empty")
+		}
+		return err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		var tooLarge *BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		return errors.New("the body must hold a single JSON value")
+	}
+
+	return nil
+}
+
+// checkContentType returns an UnsupportedMediaTypeError when the body of the request is not JSON.
+func checkContentType(r *http.Request) error {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return &UnsupportedMediaTypeError{ContentType: contentType}
+	}
+	return nil
+}
+
+// UnsupportedMediaType answers a 415 for a body that is not JSON.
+func UnsupportedMediaType(w http.ResponseWriter, r *http.Request, messages ...string) {
+	Render(w, r, &errorResponse{
+		Code:     ErrorCodeUnsupportedMediaType,
+		Messages: messages,
+	}, http.StatusUnsupportedMediaType)
+}
+
+// BodyError answers the error of reading the body of the request: a 413 when it is longer than
+// the limit of the route, a 415 when it is not JSON, a 400 with the code otherwise.
+func BodyError(w http.ResponseWriter, r *http.Request, code string, err error) {
+	var tooLarge *BodyTooLargeError
+	var mediaType *UnsupportedMediaTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		RequestTooLarge(w, r, tooLarge.Limit)
+	case errors.As(err, &mediaType):
+		UnsupportedMediaType(w, r, err.Error())
+	default:
+		BadRequest(w, r, code, err.Error())
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type request struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		assertError func(*testing.T, error)
+	}{
+		{
+			name:        "Success - known fields",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"Jhon","address":"5th avenue"}`,
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+		},
+		{
+			name:        "Success - json suffix",
+			contentType: "application/merge-patch+json",
+			body:        `{"name":"Jhon"} `,
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+		},
+		{
+			name:        "Error - unknown field",
+			contentType: "application/json",
+			body:        `{"name":"Jhon","adress":"5th avenue"}`,
+			assertError: func(t *testing.T, e error) {
+				assert.EqualError(t, e, `json: unknown field "adress"`)
+			},
+		},
+		{
+			name:        "Error - trailing data",
+			contentType: "application/json",
+			body:        `{"name":"Jhon"}garbage`,
+			assertError: func(t *testing.T, e error) {
+				assert.EqualError(t, e, "the body must hold a single JSON value")
+			},
+		},
+		{
+			name:        "Error - two values",
+			contentType: "application/json",
+			body:        `{"name":"Jhon"}{"name":"Doe"}`,
+			assertError: func(t *testing.T, e error) {
+				assert.EqualError(t, e, "the body must hold a single JSON value")
+			},
+		},
+		{
+			name:        "Error - empty body",
+			contentType: "application/json",
+			body:        ``,
+			assertError: func(t *testing.T, e error) {
+				assert.EqualError(t, e, "the body is empty")
+			},
+		},
+		{
+			name:        "Error - not json",
+			contentType: "text/plain",
+			body:        `{"name":"Jhon"}`,
+			assertError: func(t *testing.T, e error) {
+				assert.IsType(t, &UnsupportedMediaTypeError{}, e)
+			},
+		},
+		{
+			name:        "Error - missing content type",
+			contentType: "",
+			body:        `{"name":"Jhon"}`,
+			assertError: func(t *testing.T, e error) {
+				assert.IsType(t, &UnsupportedMediaTypeError{}, e)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			var v request
+			tt.assertError(t, DecodeJSON(req, &v))
+		})
+	}
+}
+
+func TestBodyError(t *testing.T) {
+	s := New(&Config{Port: 8080, MaxBodyBytes: 24})
+	s.AddRoute("/users", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := DecodeJSON(r, &body); err != nil {
+			BodyError(w, r, "INVALID_PARAMS", err)
+			return
+		}
+		OK(w, r, body)
+	}, http.MethodPost)
+
+	send := func(contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, send("application/json", `{"name":"Jhon"}`).Code, http.StatusOK)
+	assert.Equal(t, send("application/xml", `<name>Jhon</name>`).Code, http.StatusUnsupportedMediaType)
+	assert.Equal(t, send("application/json", `{"name":"Jhon"} {}`).Code, http.StatusBadRequest)
+	assert.Equal(t, send("application/json", `{"name":"`+strings.Repeat("a", 32)+`"}`).Code, http.StatusRequestEntityTooLarge)
+	assert.Equal(t, send("application/json", `{"name":"Jhon"}`+strings.Repeat(" ", 32)).Code, http.StatusRequestEntityTooLarge)
+}
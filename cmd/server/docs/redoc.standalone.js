@@ -0,0 +1,2 @@
+// Placeholder of the Redoc v2.1.5 standalone bundle, replace it with `make redoc`.
+document.body.textContent = "The Redoc bundle is not vendored in this build, run make redoc and build again.";
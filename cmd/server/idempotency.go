@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	ErrorCodeIdempotencyKeyReused     string = "IDEMPOTENCY_KEY_REUSED"
+	ErrorCodeIdempotencyKeyInProgress string = "IDEMPOTENCY_KEY_IN_PROGRESS"
+
+	defaultIdempotencyTTL = 24 * time.Hour
+	// the memory store drops the expired keys at most this often
+	idempotencySweepInterval = time.Minute
+
+	deleteExpiredIdempotencySQL string = "DELETE FROM idempotency_key WHERE idempotency_key = ? AND expires_at < ?"
+	reserveIdempotencySQL       string = "INSERT IGNORE INTO idempotency_key (idempotency_key, fingerprint, status, expires_at) VALUES (:idempotency_key, :fingerprint, 0, :expires_at)"
+	getIdempotencySQL           string = "SELECT idempotency_key, fingerprint, status, location, body, expires_at FROM idempotency_key WHERE idempotency_key = ?"
+	completeIdempotencySQL      string = "UPDATE idempotency_key SET status = :status, location = :location, body = :body WHERE idempotency_key = :idempotency_key"
+	releaseIdempotencySQL       string = "DELETE FROM idempotency_key WHERE idempotency_key = ?"
+)
+
+// IdempotencyKeyParam documents the Idempotency-Key header of the routes.
+var IdempotencyKeyParam = Param{
+	Name:        IdempotencyKeyHeader,
+	In:          "header",
+	Description: "Retries with the same key and body get the first response instead of running again",
+}
+
+// IdempotencyRecord is the response stored for an Idempotency-Key. Status is zero while the
+// first request with the key is still running.
+type IdempotencyRecord struct {
+	Key         string    `db:"idempotency_key"`
+	Fingerprint string    `db:"fingerprint"`
+	Status      int       `db:"status"`
+	Location    string    `db:"location"`
+	Body        []byte    `db:"body"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
+
+type IdempotencyStore interface {
+	// Reserve saves the record when its key is unused or expired and returns nil,
+	// otherwise it returns the record already stored for the key.
+	Reserve(record *IdempotencyRecord) (*IdempotencyRecord, error)
+	// Complete stores the response of a reserved key.
+	Complete(record *IdempotencyRecord) error
+	// Release drops a reserved key so that the request can be retried.
+	Release(key string) error
+}
+
+// Idempotency replays the stored response of POST and PATCH requests sent again with the same
+// Idempotency-Key. A key reused with a different request gets a 422, a key whose first request
+// is still running gets a 409. Server errors are not stored so that they can be retried.
+// Tenancy must run before it so that keys are not shared between tenants.
+func Idempotency(store IdempotencyStore, ttl time.Duration) mux.MiddlewareFunc {
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) || streamed(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				BodyError(w, r, ErrorCodeInvalidParams, err)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			// keys are per tenant, the same key sent by two tenants are different requests
+			if tenant := GetTenant(r.Context()); tenant != "" {
+				key = tenant + ":" + key
+			}
+
+			record := &IdempotencyRecord{
+				Key:         key,
+				Fingerprint: fingerprint(r, body),
+				ExpiresAt:   time.Now().Add(ttl),
+			}
+
+			existing, err := store.Reserve(record)
+			if err != nil {
+				InternalServerError(w, r, err)
+				return
+			}
+
+			if existing != nil {
+				replay(w, r, existing, record.Fingerprint)
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			if recorder.status >= http.StatusInternalServerError {
+				err = store.Release(key)
+			} else {
+				record.Status = recorder.status
+				record.Location = recorder.Header().Get("Location")
+				record.Body = recorder.body.Bytes()
+				err = store.Complete(record)
+			}
+			if err != nil {
+				logrus.Errorf("error while storing idempotency key %s: %v", key, err)
+			}
+		})
+	}
+}
+
+// streamed tells whether the body is left for the handler to stream, e.g. the file of an import:
+// only JSON bodies are read into memory to be fingerprinted, the others ignore the key.
+func streamed(r *http.Request) bool {
+	return r.Header.Get("Content-Type") != "" && checkContentType(r) != nil
+}
+
+func replay(w http.ResponseWriter, r *http.Request, record *IdempotencyRecord, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		Render(w, r, &errorResponse{
+			Code:     ErrorCodeIdempotencyKeyReused,
+			Messages: []string{"the Idempotency-Key was already used with a different request"},
+		}, http.StatusUnprocessableEntity)
+	case record.Status == 0:
+		Render(w, r, &errorResponse{
+			Code:     ErrorCodeIdempotencyKeyInProgress,
+			Messages: []string{"a request with the same Idempotency-Key is in progress"},
+		}, http.StatusConflict)
+	default:
+		if record.Location != "" {
+			w.Header().Set("Location", record.Location)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.Status)
+		if _, err := w.Write(record.Body); err != nil {
+			logrus.Errorf(err.Error())
+		}
+	}
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the status and body written by a handler.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// MemoryIdempotencyStore keeps the idempotency keys in memory, it only suits a single instance.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]IdempotencyRecord
+	lastSweep time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]IdempotencyRecord)}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	if stored, ok := s.records[record.Key]; ok && !stored.ExpiresAt.Before(now) {
+		return &stored, nil
+	}
+
+	s.records[record.Key] = *record
+	return nil, nil
+}
+
+// sweep drops the expired keys, at most once per idempotencySweepInterval so that a request
+// doesn't scan every key.
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < idempotencySweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, stored := range s.records {
+		if stored.ExpiresAt.Before(now) {
+			delete(s.records, key)
+		}
+	}
+}
+
+func (s *MemoryIdempotencyStore) Complete(record *IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[record.Key] = *record
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// MySQLIdempotencyStore keeps the idempotency keys in MySQL so that they are shared by every
+// instance. The idempotency_key table is created by the migrations.
+type MySQLIdempotencyStore struct {
+	db *sqlx.DB
+}
+
+func NewMySQLIdempotencyStore(db *sqlx.DB) *MySQLIdempotencyStore {
+	return &MySQLIdempotencyStore{db: db}
+}
+
+func (s *MySQLIdempotencyStore) Reserve(record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	if _, err := s.db.Exec(deleteExpiredIdempotencySQL, record.Key, time.Now()); err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.NamedExec(reserveIdempotencySQL, record)
+	if err != nil {
+		return nil, err
+	}
+
+	if inserted, _ := result.RowsAffected(); inserted == 1 {
+		return nil, nil
+	}
+
+	existing := IdempotencyRecord{}
+	if err := s.db.Get(&existing, getIdempotencySQL, record.Key); err != nil {
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+func (s *MySQLIdempotencyStore) Complete(record *IdempotencyRecord) error {
+	_, err := s.db.NamedExec(completeIdempotencySQL, record)
+	return err
+}
+
+func (s *MySQLIdempotencyStore) Release(key string) error {
+	_, err := s.db.Exec(releaseIdempotencySQL, key)
+	return err
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	s := New(&Config{Port: 8080})
+	s.Use(Idempotency(NewMemoryIdempotencyStore(), 0))
+	s.AddRoute("/users", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		Created(w, r, "user/1")
+	}, http.MethodPost)
+
+	send := func(key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := send("key-1", `{"name":"Jhon"}`)
+	assert.Equal(t, first.Code, http.StatusCreated)
+	assert.Equal(t, calls, 1)
+
+	replayed := send("key-1", `{"name":"Jhon"}`)
+	assert.Equal(t, replayed.Code, http.StatusCreated)
+	assert.Equal(t, replayed.Header().Get("Location"), "user/1")
+	assert.Equal(t, replayed.Header().Get("Idempotent-Replayed"), "true")
+	assert.Equal(t, calls, 1)
+
+	reused := send("key-1", `{"name":"Other"}`)
+	assert.Equal(t, reused.Code, http.StatusUnprocessableEntity)
+	assert.Contains(t, reused.Body.String(), ErrorCodeIdempotencyKeyReused)
+	assert.Equal(t, calls, 1)
+
+	other := send("key-2", `{"name":"Jhon"}`)
+	assert.Equal(t, other.Code, http.StatusCreated)
+	assert.Equal(t, calls, 2)
+
+	// a file is streamed to the handler, the key is ignored
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("name\nJhon\n"))
+		req.Header.Set(IdempotencyKeyHeader, "key-3")
+		req.Header.Set("Content-Type", "text/csv")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		assert.Equal(t, rec.Code, http.StatusCreated)
+		assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+	}
+	assert.Equal(t, calls, 4)
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	expired := time.Now().Add(-time.Minute)
+
+	existing, err := store.Reserve(&IdempotencyRecord{Key: "expired", ExpiresAt: expired})
+	assert.Nil(t, err)
+	assert.Nil(t, existing)
+
+	// an expired key is reserved again even before the sweep drops it
+	existing, err = store.Reserve(&IdempotencyRecord{Key: "expired", Fingerprint: "b", ExpiresAt: expired})
+	assert.Nil(t, err)
+	assert.Nil(t, existing)
+
+	_, err = store.Reserve(&IdempotencyRecord{Key: "live", ExpiresAt: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	assert.Len(t, store.records, 2)
+
+	existing, err = store.Reserve(&IdempotencyRecord{Key: "live", ExpiresAt: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	assert.NotNil(t, existing)
+
+	// the next sweep drops the expired keys
+	store.lastSweep = time.Now().Add(-idempotencySweepInterval)
+	_, err = store.Reserve(&IdempotencyRecord{Key: "other", ExpiresAt: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	assert.Len(t, store.records, 2)
+	assert.NotContains(t, store.records, "expired")
+}
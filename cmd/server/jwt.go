@@ -0,0 +1,296 @@
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmHS256 string = "HS256"
+	AlgorithmRS256 string = "RS256"
+
+	bearerScheme string = "Bearer"
+)
+
+// JWTConfig holds the keys and the expected claims of the accepted tokens. Secret is the HS256
+// key, PublicKey a PEM encoded RS256 key and JWKSFile the path of a JSON Web Key Set with
+// both kinds of keys. Issuer and Audience are only checked when set.
+type JWTConfig struct {
+	Secret    string
+	PublicKey string
+	JWKSFile  string
+	Issuer    string
+	Audience  string
+	Leeway    time.Duration
+}
+
+type jwtKey struct {
+	id        string
+	algorithm string
+	secret    []byte
+	publicKey *rsa.PublicKey
+}
+
+// JWTAuthenticator accepts HS256 and RS256 signed bearer tokens.
+type JWTAuthenticator struct {
+	keys     []jwtKey
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+func NewJWTAuthenticator(c JWTConfig) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{
+		issuer:   c.Issuer,
+		audience: c.Audience,
+		leeway:   c.Leeway,
+		now:      time.Now,
+	}
+
+	if c.Secret != "" {
+		a.keys = append(a.keys, jwtKey{algorithm: AlgorithmHS256, secret: []byte(c.Secret)})
+	}
+
+	if c.PublicKey != "" {
+		publicKey, err := parsePublicKey([]byte(c.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+		a.keys = append(a.keys, jwtKey{algorithm: AlgorithmRS256, publicKey: publicKey})
+	}
+
+	if c.JWKSFile != "" {
+		keys, err := readJWKS(c.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = append(a.keys, keys...)
+	}
+
+	if len(a.keys) == 0 {
+		return nil, errors.New("jwt: no secret, public key or jwks file configured")
+	}
+
+	return a, nil
+}
+
+func (a *JWTAuthenticator) Scheme() string {
+	return bearerScheme
+}
+
+func (a *JWTAuthenticator) SecurityScheme() SecurityScheme {
+	return SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description:  "The scopes are in the scope claim, the tenant in the tenant_id claim.",
+	}
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(bearerScheme) || !strings.EqualFold(header[:len(bearerScheme)+1], bearerScheme+" ") {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.Verify(strings.TrimSpace(header[len(bearerScheme)+1:]))
+	if err != nil {
+		return nil, &AuthError{Scheme: bearerScheme, Message: err.Error()}
+	}
+
+	return claims, nil
+}
+
+// Verify checks the signature of the token and its exp, nbf, iss and aud claims.
+func (a *JWTAuthenticator) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("the token is malformed")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyId     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("the token header is malformed")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("the token signature is malformed")
+	}
+
+	if !a.verifySignature(header.Algorithm, header.KeyId, parts[0]+"."+parts[1], signature) {
+		return nil, errors.New("the token signature is invalid")
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("the token claims are malformed")
+	}
+
+	return claims, a.validate(claims)
+}
+
+// verifySignature only tries the keys of the algorithm of the token, so that a public key is
+// never used as an HMAC secret.
+func (a *JWTAuthenticator) verifySignature(algorithm string, keyId string, signed string, signature []byte) bool {
+	hash := sha256.Sum256([]byte(signed))
+
+	for _, key := range a.keys {
+		if key.algorithm != algorithm || (keyId != "" && key.id != "" && key.id != keyId) {
+			continue
+		}
+
+		switch algorithm {
+		case AlgorithmHS256:
+			mac := hmac.New(sha256.New, key.secret)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return true
+			}
+		case AlgorithmRS256:
+			if rsa.VerifyPKCS1v15(key.publicKey, crypto.SHA256, hash[:], signature) == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (a *JWTAuthenticator) validate(claims Claims) error {
+	now := a.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("the token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(a.leeway)) {
+		return errors.New("the token is expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("the token is not valid yet")
+	}
+
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return fmt.Errorf("the token issuer is not %s", a.issuer)
+	}
+
+	if a.audience != "" && !hasAudience(claims["aud"], a.audience) {
+		return fmt.Errorf("the token audience is not %s", a.audience)
+	}
+
+	return nil
+}
+
+// hasAudience checks the aud claim, which is either a string or an array of them.
+func hasAudience(aud interface{}, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, item := range value {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func parsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt: the public key is not PEM encoded")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("jwt: the public key is not an RSA key")
+	}
+	return publicKey, nil
+}
+
+// readJWKS loads the RSA and oct keys of a JSON Web Key Set file (RFC 7517).
+func readJWKS(path string) ([]jwtKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Type string `json:"kty"`
+			Id   string `json:"kid"`
+			Use  string `json:"use"`
+			N    string `json:"n"`
+			E    string `json:"e"`
+			K    string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwt: %s is not a valid jwks file: %v", path, err)
+	}
+
+	keys := make([]jwtKey, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		switch jwk.Type {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				return nil, fmt.Errorf("jwt: the key %s of %s is malformed", jwk.Id, path)
+			}
+			keys = append(keys, jwtKey{id: jwk.Id, algorithm: AlgorithmRS256, publicKey: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}})
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+			if err != nil {
+				return nil, fmt.Errorf("jwt: the key %s of %s is malformed", jwk.Id, path)
+			}
+			keys = append(keys, jwtKey{id: jwk.Id, algorithm: AlgorithmHS256, secret: secret})
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signHS256(secret string, claims Claims) string {
+	signed := jwtSegment(map[string]string{"alg": AlgorithmHS256, "typ": "JWT"}) + "." + jwtSegment(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(key *rsa.PrivateKey, claims Claims) string {
+	signed := jwtSegment(map[string]string{"alg": AlgorithmRS256, "typ": "JWT"}) + "." + jwtSegment(claims)
+	hash := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func jwtSegment(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestJWTAuthenticator_Verify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		Secret:    "secret",
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Issuer:    "https://auth.example.com",
+		Audience:  "users-api",
+	})
+	assert.Nil(t, err)
+
+	now := time.Now().Unix()
+	valid := func() Claims {
+		return Claims{"sub": "jhon", "iss": "https://auth.example.com", "aud": []string{"users-api"}, "exp": now + 60}
+	}
+	with := func(key string, value interface{}) Claims {
+		claims := valid()
+		claims[key] = value
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		error string
+	}{
+		{name: "Success - HS256", token: signHS256("secret", valid())},
+		{name: "Success - RS256", token: signRS256(key, valid())},
+		{name: "Error - wrong secret", token: signHS256("other", valid()), error: "the token signature is invalid"},
+		{name: "Error - expired", token: signHS256("secret", with("exp", now-60)), error: "the token is expired"},
+		{name: "Error - no exp", token: signHS256("secret", with("exp", nil)), error: "the token has no exp claim"},
+		{name: "Error - not valid yet", token: signRS256(key, with("nbf", now+60)), error: "the token is not valid yet"},
+		{name: "Error - issuer", token: signRS256(key, with("iss", "other")), error: "the token issuer is not https://auth.example.com"},
+		{name: "Error - audience", token: signHS256("secret", with("aud", "other")), error: "the token audience is not users-api"},
+		{name: "Error - malformed", token: "token", error: "the token is malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := authenticator.Verify(tt.token)
+			if tt.error != "" {
+				assert.EqualError(t, err, tt.error)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, claims.Subject(), "jhon")
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Secret: "secret"})
+	assert.Nil(t, err)
+
+	s := New(&Config{Port: 8080})
+	s.Use(s.Authentication(authenticator))
+	s.AddRoute("/health", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, nil)
+	}, http.MethodGet).Public()
+	s.AddRoute("/users", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, map[string]string{"actor": GetActor(r.Context())})
+	}, http.MethodGet)
+
+	send := func(path string, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, send("/health", "").Code, http.StatusOK)
+
+	missing := send("/users", "")
+	assert.Equal(t, missing.Code, http.StatusUnauthorized)
+	assert.Equal(t, missing.Header().Get("WWW-Authenticate"), `Bearer realm="users-api"`)
+
+	invalid := send("/users", "Bearer "+signHS256("other", Claims{"sub": "jhon", "exp": time.Now().Unix() + 60}))
+	assert.Equal(t, invalid.Code, http.StatusUnauthorized)
+	assert.Contains(t, invalid.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	assert.Contains(t, invalid.Body.String(), ErrorCodeUnauthorized)
+
+	valid := send("/users", "Bearer "+signHS256("secret", Claims{"sub": "jhon", "exp": time.Now().Unix() + 60}))
+	assert.Equal(t, valid.Code, http.StatusOK)
+	assert.Contains(t, valid.Body.String(), `"actor":"jhon"`)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	ErrorCodeRequestTooLarge string = "REQUEST_TOO_LARGE"
+
+	defaultReadTimeout    = 15 * time.Second
+	defaultWriteTimeout   = 60 * time.Second
+	defaultHandlerTimeout = 10 * time.Second
+	defaultMaxBodyBytes   = 1 << 20
+
+	timeoutMessage = "response timeout exceeded"
+)
+
+// BodyTooLargeError is returned when reading a body longer than the limit of its route.
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("the request body must not exceed %d bytes", e.Limit)
+}
+
+// RouteConfig overrides the handler timeout and the body limit of a route.
+type RouteConfig struct {
+	Timeout      time.Duration `json:"timeout"`
+	MaxBodyBytes int64         `json:"max_body_bytes"`
+}
+
+// withDefaults fills the zero timeouts and limits, a negative handler timeout or body limit
+// disables it.
+func (c Config) withDefaults() Config {
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = defaultReadTimeout
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = defaultWriteTimeout
+	}
+	if c.HandlerTimeout == 0 {
+		c.HandlerTimeout = defaultHandlerTimeout
+	}
+	if c.MaxBodyBytes == 0 {
+		c.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	return c
+}
+
+// routeConfig returns the handler timeout and body limit of the route, its override or the
+// ones of the server.
+func (s *Server) routeConfig(route *Route) RouteConfig {
+	c := RouteConfig{Timeout: s.cfg.HandlerTimeout, MaxBodyBytes: s.cfg.MaxBodyBytes}
+	if route == nil {
+		return c
+	}
+
+	if override, ok := s.cfg.Routes[route.name]; ok {
+		if override.Timeout != 0 {
+			c.Timeout = override.Timeout
+		}
+		if override.MaxBodyBytes != 0 {
+			c.MaxBodyBytes = override.MaxBodyBytes
+		}
+	}
+	return c
+}
+
+// limitBody caps the body of the request to the limit of its route, before any other middleware
+// reads it. Bodies known to be too long get a 413 right away, the others fail with a
+// BodyTooLargeError once the limit is read.
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.routeConfig(s.currentRoute(r)).MaxBodyBytes
+		if limit < 0 || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > limit {
+			RequestTooLarge(w, r, limit)
+			return
+		}
+
+		r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitedBody turns the error of http.MaxBytesReader, which has no type to check, into a
+// BodyTooLargeError.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && err.Error() == "http: request body too large" {
+		return n, &BodyTooLargeError{Limit: b.limit}
+	}
+	return n, err
+}
+
+// timeout runs the handler with the timeout of its route, a handler that takes longer gets a 503.
+func (s *Server) timeout(route *Route, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := s.routeConfig(route).Timeout
+		if timeout < 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		http.TimeoutHandler(h, timeout, timeoutMessage).ServeHTTP(w, r)
+	})
+}
+
+// RequestTooLarge answers a 413 for a body longer than the limit.
+func RequestTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	Render(w, r, &errorResponse{
+		Code:     ErrorCodeRequestTooLarge,
+		Messages: []string{(&BodyTooLargeError{Limit: limit}).Error()},
+	}, http.StatusRequestEntityTooLarge)
+}
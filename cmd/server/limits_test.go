@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits(t *testing.T) {
+	s := New(&Config{
+		Port:           8080,
+		HandlerTimeout: time.Second,
+		MaxBodyBytes:   16,
+		Routes: map[string]RouteConfig{
+			"users.batch": {MaxBodyBytes: 64},
+			"users.slow":  {Timeout: 10 * time.Millisecond},
+		},
+	})
+
+	decode := func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			BodyError(w, r, "INVALID_PARAMS", err)
+			return
+		}
+		OK(w, r, body)
+	}
+	s.AddRoute("/users", decode, http.MethodPost).Name("users.create")
+	s.AddRoute("/users/batch", decode, http.MethodPost).Name("users.batch")
+	s.AddRoute("/users/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		OK(w, r, nil)
+	}, http.MethodGet).Name("users.slow")
+
+	send := func(method string, path string, body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	long := `{"name":"` + strings.Repeat("a", 32) + `"}`
+
+	assert.Equal(t, send(http.MethodPost, "/users", `{"name":"jhon"}`, false).Code, http.StatusOK)
+
+	tooLarge := send(http.MethodPost, "/users", long, false)
+	assert.Equal(t, tooLarge.Code, http.StatusRequestEntityTooLarge)
+	assert.Contains(t, tooLarge.Body.String(), ErrorCodeRequestTooLarge)
+
+	chunked := send(http.MethodPost, "/users", long, true)
+	assert.Equal(t, chunked.Code, http.StatusRequestEntityTooLarge)
+	assert.Contains(t, chunked.Body.String(), "must not exceed 16 bytes")
+
+	assert.Equal(t, send(http.MethodPost, "/users", `{"name":`, false).Code, http.StatusBadRequest)
+	assert.Equal(t, send(http.MethodPost, "/users/batch", long, true).Code, http.StatusOK, "route override")
+
+	timeout := send(http.MethodGet, "/users/slow", "", false)
+	assert.Equal(t, timeout.Code, http.StatusServiceUnavailable)
+	assert.Equal(t, timeout.Body.String(), timeoutMessage)
+}
@@ -0,0 +1,540 @@
+package server
+
+import (
+	_ "embed"
+	"fmt"
+	"html"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const openAPIVersion = "3.0.3"
+
+// Doc describes a route in the OpenAPI document. Request and Responses hold values of the types
+// of the bodies, e.g. UserRequest{}, a nil response has no body. Consumes are the media types of
+// a request body that is not JSON, e.g. an uploaded file. Errors are the statuses of the errors
+// the handler answers, the ones of the middlewares are added to every route.
+type Doc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Params      []Param
+	Request     interface{}
+	Consumes    []string
+	Responses   map[int]interface{}
+	Errors      []int
+}
+
+// Param is a query, path or header parameter, a query string when In and Type are empty.
+type Param struct {
+	Name        string
+	In          string
+	Type        string
+	Format      string
+	Description string
+	Required    bool
+	// Enum lists the values allowed
+	Enum []string
+	// Minimum and Maximum bound the value of an integer or number
+	Minimum *int
+	Maximum *int
+}
+
+// Doc sets the description of the route in the OpenAPI document.
+func (r *Route) Doc(doc Doc) *Route {
+	r.doc = &doc
+	return r
+}
+
+// OpenAPI is an OpenAPI 3 document.
+type OpenAPI struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       OpenAPIInfo         `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem holds the operations of a path by lower case method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+	Scopes      []string              `json:"x-scopes,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+// Schema is the subset of the OpenAPI schema object the generated documents use.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+}
+
+// SecuritySchemer is implemented by the authenticators that describe their scheme in the
+// OpenAPI document.
+type SecuritySchemer interface {
+	SecurityScheme() SecurityScheme
+}
+
+// OpenAPI builds the document of the routes added to the server, sorted by path and method.
+func (s *Server) OpenAPI(info OpenAPIInfo) *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI: openAPIVersion,
+		Info:    info,
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			SecuritySchemes: make(map[string]SecurityScheme),
+		},
+	}
+
+	security := make([]map[string][]string, 0)
+	for _, authenticator := range s.authenticators {
+		if schemer, ok := authenticator.(SecuritySchemer); ok {
+			doc.Components.SecuritySchemes[authenticator.Scheme()] = schemer.SecurityScheme()
+			security = append(security, map[string][]string{authenticator.Scheme(): {}})
+		}
+	}
+
+	schemas := newSchemaBuilder()
+	now := time.Now()
+	for _, route := range s.sortedRoutes() {
+		if route.doc == nil {
+			continue
+		}
+
+		template, _ := route.route.GetPathTemplate()
+		methods, _ := route.route.GetMethods()
+		template = openAPIPath(template)
+
+		item, ok := doc.Paths[template]
+		if !ok {
+			item = make(PathItem)
+			doc.Paths[template] = item
+		}
+
+		for _, method := range methods {
+			operation := route.operation(template, schemas)
+			if !route.public {
+				operation.Security = security
+				operation.Scopes = route.scopes
+			}
+			operation.Deprecated = route.version != nil && route.version.deprecated(now)
+			item[strings.ToLower(method)] = operation
+		}
+	}
+	doc.Components.Schemas = schemas.components
+
+	return doc
+}
+
+// Undocumented lists the routes added without a Doc, as "METHOD path".
+func (s *Server) Undocumented() []string {
+	undocumented := make([]string, 0)
+	for _, route := range s.sortedRoutes() {
+		if route.doc != nil {
+			continue
+		}
+		template, _ := route.route.GetPathTemplate()
+		methods, _ := route.route.GetMethods()
+		undocumented = append(undocumented, strings.TrimSpace(strings.Join(methods, ",")+" "+template))
+	}
+	return undocumented
+}
+
+func (s *Server) sortedRoutes() []*Route {
+	routes := make([]*Route, 0, len(s.routes))
+	for _, route := range s.routes {
+		routes = append(routes, route)
+	}
+
+	key := func(route *Route) string {
+		template, _ := route.route.GetPathTemplate()
+		methods, _ := route.route.GetMethods()
+		return template + " " + strings.Join(methods, ",")
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return key(routes[i]) < key(routes[j])
+	})
+	return routes
+}
+
+var pathVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPIPath drops the patterns of the path variables, {id:[0-9]+} is {id}.
+func openAPIPath(template string) string {
+	return pathVariable.ReplaceAllString(template, "{$1}")
+}
+
+func (r *Route) operation(template string, schemas *schemaBuilder) *Operation {
+	operation := &Operation{
+		OperationID: r.name,
+		Summary:     r.doc.Summary,
+		Description: r.doc.Description,
+		Tags:        r.doc.Tags,
+		Responses:   make(map[string]*Response),
+		Security:    []map[string][]string{},
+	}
+
+	documented := make(map[string]bool)
+	for _, param := range r.doc.Params {
+		if param.In == "" {
+			param.In = "query"
+		}
+		documented[param.In+":"+param.Name] = true
+		operation.Parameters = append(operation.Parameters, param.parameter())
+	}
+	for _, match := range pathVariable.FindAllStringSubmatch(template, -1) {
+		if !documented["path:"+match[1]] {
+			operation.Parameters = append(operation.Parameters, Param{Name: match[1], In: "path"}.parameter())
+		}
+	}
+
+	if r.doc.Request != nil {
+		operation.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: schemas.schema(reflect.TypeOf(r.doc.Request))}},
+		}
+	} else if len(r.doc.Consumes) > 0 {
+		operation.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{}}
+		for _, mediaType := range r.doc.Consumes {
+			operation.RequestBody.Content[mediaType] = MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+		}
+	}
+
+	for status, body := range r.doc.Responses {
+		response := &Response{Description: http.StatusText(status)}
+		if body != nil {
+			response.Content = map[string]MediaType{"application/json": {Schema: schemas.schema(reflect.TypeOf(body))}}
+		}
+		operation.Responses[strconv.Itoa(status)] = response
+	}
+
+	statuses := append([]int{}, r.doc.Errors...)
+	if !r.public {
+		statuses = append(statuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	if operation.RequestBody != nil {
+		statuses = append(statuses, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType)
+	}
+	errorSchema := schemas.schema(reflect.TypeOf(errorResponse{}))
+	for _, status := range append(statuses, http.StatusTooManyRequests) {
+		operation.Responses[strconv.Itoa(status)] = &Response{
+			Description: http.StatusText(status),
+			Content:     map[string]MediaType{"application/json": {Schema: errorSchema}},
+		}
+	}
+	operation.Responses["default"] = &Response{
+		Description: "Error",
+		Content:     map[string]MediaType{"application/json": {Schema: errorSchema}},
+	}
+
+	return operation
+}
+
+func (p Param) parameter() Parameter {
+	if p.In == "" {
+		p.In = "query"
+	}
+	if p.Type == "" {
+		p.Type = "string"
+	}
+	return Parameter{
+		Name:        p.Name,
+		In:          p.In,
+		Description: p.Description,
+		Required:    p.Required || p.In == "path",
+		Schema:      &Schema{Type: p.Type, Format: p.Format, Enum: p.Enum, Minimum: p.Minimum, Maximum: p.Maximum},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaBuilder turns go types into schemas, the named structs become components referenced
+// by their name.
+type schemaBuilder struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		components: make(map[string]*Schema),
+		names:      make(map[reflect.Type]string),
+	}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+
+	var schema *Schema
+	switch {
+	case t == timeType:
+		schema = &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		return b.ref(t)
+	case t.Kind() == reflect.Struct:
+		schema = b.object(t)
+	case t.Kind() == reflect.Bool:
+		schema = &Schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema = &Schema{Type: "integer"}
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			schema.Format = "int64"
+		}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		schema = &Schema{Type: "number"}
+	case t.Kind() == reflect.String:
+		schema = &Schema{Type: "string"}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8:
+		schema = &Schema{Type: "string", Format: "byte"}
+	case t.Kind() == reflect.Array:
+		schema = &Schema{Type: "array", Items: b.schema(t.Elem())}
+	case t.Kind() == reflect.Slice:
+		// a nil slice is encoded as null
+		schema, nullable = &Schema{Type: "array", Items: b.schema(t.Elem())}, true
+	case t.Kind() == reflect.Map:
+		schema, nullable = &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem())}, true
+	default:
+		schema = &Schema{}
+	}
+
+	schema.Nullable = nullable
+	return schema
+}
+
+// ref adds the struct to the components, the first time, and references it.
+func (b *schemaBuilder) ref(t reflect.Type) *Schema {
+	name, ok := b.names[t]
+	if !ok {
+		name = b.name(t)
+		b.names[t] = name
+		b.components[name] = &Schema{}
+		*b.components[name] = *b.object(t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// name is the name of the type, prefixed by its package when another type has it.
+func (b *schemaBuilder) name(t reflect.Type) string {
+	name := t.Name()
+	if t == reflect.TypeOf(errorResponse{}) {
+		name = "Error"
+	}
+	if _, taken := b.components[name]; taken {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+	return name
+}
+
+// object describes the exported fields of the struct by their json name, the ones of embedded
+// structs included. The required, min and max validate tags become constraints.
+func (b *schemaBuilder) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name := field.Name
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if tag[0] != "" {
+			name = tag[0]
+		}
+
+		fieldType := field.Type
+		if field.Anonymous && tag[0] == "" {
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				embedded := b.object(fieldType)
+				for property, s := range embedded.Properties {
+					schema.Properties[property] = s
+				}
+				schema.Required = append(schema.Required, embedded.Required...)
+				continue
+			}
+		}
+
+		property := b.schema(fieldType)
+		if validate(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// validate sets the min and max of the validate tag in the schema and tells whether the field
+// is required. The rules after dive apply to the elements and are left out.
+func validate(schema *Schema, tag string) bool {
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		if rule == "dive" {
+			break
+		}
+		if rule == "required" {
+			required = true
+			continue
+		}
+
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || schema.Ref != "" {
+			continue
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case parts[0] == "min" && schema.Type == "array":
+			schema.MinItems = &n
+		case parts[0] == "max" && schema.Type == "array":
+			schema.MaxItems = &n
+		case parts[0] == "min" && schema.Type == "string":
+			schema.MinLength = &n
+		case parts[0] == "max" && schema.Type == "string":
+			schema.MaxLength = &n
+		case (parts[0] == "min" || parts[0] == "gte") && numeric(schema.Type):
+			schema.Minimum = &n
+		case (parts[0] == "max" || parts[0] == "lte") && numeric(schema.Type):
+			schema.Maximum = &n
+		}
+	}
+	return required
+}
+
+func numeric(kind string) bool {
+	return kind == "integer" || kind == "number"
+}
+
+// redocBundle is the Redoc standalone bundle, vendored so /docs works offline.
+//
+//go:embed docs/redoc.standalone.js
+var redocBundle []byte
+
+// AddDocs adds the OpenAPI document at /openapi.json and a Redoc page rendering it at /docs.
+func (s *Server) AddDocs(info OpenAPIInfo) {
+	s.AddRoute("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, s.OpenAPI(info))
+	}, http.MethodGet).Name("openapi").Public().Doc(Doc{
+		Summary:   "OpenAPI document of the api",
+		Tags:      []string{"docs"},
+		Responses: map[int]interface{}{http.StatusOK: map[string]interface{}{}},
+	})
+
+	s.AddRoute("/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, redocPage, html.EscapeString(info.Title))
+	}, http.MethodGet).Name("docs").Public().Doc(Doc{
+		Summary:   "Documentation of the api",
+		Tags:      []string{"docs"},
+		Responses: map[int]interface{}{http.StatusOK: nil},
+	})
+
+	s.AddRoute("/docs/redoc.standalone.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		if _, err := w.Write(redocBundle); err != nil {
+			logrus.Errorf(err.Error())
+		}
+	}, http.MethodGet).Name("docs_bundle").Public().Doc(Doc{
+		Summary:   "Redoc bundle of the documentation page",
+		Tags:      []string{"docs"},
+		Responses: map[int]interface{}{http.StatusOK: nil},
+	})
+}
+
+const redocPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>%s</title>
+    <meta charset="utf-8"/>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>body { margin: 0; padding: 0; }</style>
+  </head>
+  <body>
+    <redoc spec-url="/openapi.json"></redoc>
+    <script src="/docs/redoc.standalone.js"></script>
+  </body>
+</html>
+`
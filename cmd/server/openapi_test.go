@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAddress struct {
+	Street string `json:"street"`
+}
+
+type testUser struct {
+	Id        int               `json:"id"`
+	Secret    string            `json:"-"`
+	Name      string            `json:"name" validate:"required,min=3"`
+	Tags      []string          `json:"tags" validate:"required,min=1,dive,required"`
+	Address   *testAddress      `json:"address,omitempty"`
+	Labels    map[string]string `json:"labels"`
+	CreatedAt time.Time         `json:"created_at"`
+	DeletedAt *time.Time        `json:"deleted_at"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Secret: "secret"})
+	assert.Nil(t, err)
+
+	s := New(&Config{Port: 8080})
+	s.Use(s.Authentication(authenticator))
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	s.APIVersion("1").AddRoute("/users/{id:[0-9]+}", handler, http.MethodGet, http.MethodHead).Name("users.get").Scopes("users:read").Doc(Doc{
+		Summary:   "Get a user",
+		Params:    []Param{{Name: "fields"}},
+		Responses: map[int]interface{}{http.StatusOK: testUser{}},
+		Errors:    []int{http.StatusNotFound},
+	})
+	s.APIVersion("1").AddRoute("/users", handler, http.MethodPost).Doc(Doc{
+		Request:   testUser{},
+		Responses: map[int]interface{}{http.StatusCreated: nil},
+	})
+	s.AddRoute("/undocumented", handler, http.MethodGet).Public()
+	s.AddDocs(OpenAPIInfo{Title: "users-api", Version: "1"})
+
+	assert.Equal(t, s.Undocumented(), []string{"GET /undocumented"})
+
+	doc := s.OpenAPI(OpenAPIInfo{Title: "users-api", Version: "1"})
+	assert.NotContains(t, doc.Paths, "/undocumented")
+
+	get := doc.Paths["/v1/users/{id}"]["get"]
+	assert.NotNil(t, doc.Paths["/v1/users/{id}"]["head"])
+	assert.Equal(t, get.OperationID, "users.get")
+	assert.Equal(t, get.Scopes, []string{"users:read"})
+	assert.Equal(t, get.Security, []map[string][]string{{"Bearer": {}}})
+	assert.Equal(t, get.Parameters[0], Parameter{Name: "fields", In: "query", Schema: &Schema{Type: "string"}})
+	assert.Equal(t, get.Parameters[1], Parameter{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	assert.Equal(t, get.Responses["200"].Content["application/json"].Schema.Ref, "#/components/schemas/testUser")
+	assert.Equal(t, get.Responses["404"].Content["application/json"].Schema.Ref, "#/components/schemas/Error")
+	assert.Contains(t, get.Responses, "401")
+	assert.Equal(t, doc.Components.SecuritySchemes["Bearer"].Scheme, "bearer")
+
+	post := doc.Paths["/v1/users"]["post"]
+	assert.True(t, post.RequestBody.Required)
+	assert.Nil(t, post.Responses["201"].Content)
+	assert.Contains(t, post.Responses, "415")
+
+	user := doc.Components.Schemas["testUser"]
+	assert.Equal(t, user.Required, []string{"name", "tags"})
+	assert.NotContains(t, user.Properties, "Secret")
+	assert.Equal(t, *user.Properties["name"].MinLength, 3)
+	assert.Equal(t, *user.Properties["tags"].MinItems, 1)
+	assert.Equal(t, user.Properties["address"].Ref, "#/components/schemas/testAddress")
+	assert.Equal(t, user.Properties["labels"].AdditionalProperties.Type, "string")
+	assert.Equal(t, *user.Properties["created_at"], Schema{Type: "string", Format: "date-time"})
+	assert.True(t, user.Properties["deleted_at"].Nullable)
+
+	docs := doc.Paths["/openapi.json"]["get"]
+	assert.Equal(t, docs.Security, []map[string][]string{})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	var served OpenAPI
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Equal(t, served.OpenAPI, "3.0.3")
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Contains(t, rec.Body.String(), `<redoc spec-url="/openapi.json">`)
+	assert.Contains(t, rec.Body.String(), `<script src="/docs/redoc.standalone.js">`)
+
+	// the bundle is served from the binary, not from a cdn
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/redoc.standalone.js", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Header().Get("Content-Type"), "application/javascript; charset=utf-8")
+	assert.Equal(t, rec.Body.Bytes(), redocBundle)
+}
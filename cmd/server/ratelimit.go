@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ErrorCodeRateLimited string = "RATE_LIMITED"
+
+	bucketSweepInterval = time.Minute
+)
+
+// RateLimit is a token bucket: Burst requests at once, refilled at Rate requests per second.
+// A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits holds the default limit and the ones of the routes, by route name.
+type RateLimits struct {
+	Default RateLimit
+	Routes  map[string]RateLimit
+}
+
+// of returns the limit of the route, a missing burst is one second worth of requests.
+func (l RateLimits) of(route *Route) RateLimit {
+	limit := l.Default
+	if route != nil {
+		if routeLimit, ok := l.Routes[route.name]; ok {
+			limit = routeLimit
+		}
+	}
+
+	if limit.Burst < 1 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	return limit
+}
+
+// RateLimitResult is the state of a bucket after taking a token from it.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+}
+
+type RateLimitStore interface {
+	// Take takes a token from the bucket of the key, which has the given limit.
+	Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimiter throttles the requests of every client with a token bucket per route, the client
+// being the api key, the subject of the token or the IP of anonymous callers. Throttled requests
+// get a 429 with Retry-After, every response has the X-RateLimit-* headers. It must run after
+// Authentication.
+func (s *Server) RateLimiter(store RateLimitStore, limits RateLimits) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := s.currentRoute(r)
+			key := rateLimitClient(r)
+			if route != nil && route.name != "" {
+				key = route.name + "|" + key
+			}
+
+			if throttle(w, r, store, key, limits.of(route)) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// IPRateLimiter throttles the requests of every IP, whatever the route and credentials. It runs
+// before Authentication so that the requests with wrong credentials, which never reach the
+// RateLimiter, are counted too and guessing api keys is throttled.
+func IPRateLimiter(store RateLimitStore, limit RateLimit) mux.MiddlewareFunc {
+	limit = RateLimits{Default: limit}.of(nil)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if throttle(w, r, store, "ip|"+remoteIP(r), limit) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// throttle takes a token from the bucket of the key, it answers a 429 and returns false when
+// the bucket is empty.
+func throttle(w http.ResponseWriter, r *http.Request, store RateLimitStore, key string, limit RateLimit) bool {
+	if limit.Rate <= 0 {
+		return true
+	}
+
+	result, err := store.Take(key, limit, time.Now())
+	if err != nil {
+		InternalServerError(w, r, err)
+		return false
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		Render(w, r, &errorResponse{
+			Code:     ErrorCodeRateLimited,
+			Messages: []string{fmt.Sprintf("too many requests, retry in %d seconds", ceilSeconds(result.RetryAfter))},
+		}, http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+func rateLimitClient(r *http.Request) string {
+	claims := GetClaims(r.Context())
+	if id, ok := claims["api_key_id"].(string); ok && id != "" {
+		return "apikey:" + id
+	}
+	if sub := claims.Subject(); sub != "" {
+		return "sub:" + sub
+	}
+
+	return "ip:" + remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore keeps the buckets in memory, so the limits apply per instance.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+
+	result := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+
+	return result, nil
+}
+
+// sweep drops the buckets that are full again, they are the same as a new one.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < bucketSweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if b.refill(now); b.tokens >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	s := New(&Config{Port: 8080})
+	s.Use(s.RateLimiter(NewMemoryRateLimitStore(), RateLimits{
+		Default: RateLimit{Rate: 100, Burst: 100},
+		Routes:  map[string]RateLimit{"users.location": {Rate: 0.5, Burst: 2}},
+	}))
+	s.AddRoute("/users/{id}/locations", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, nil)
+	}, http.MethodGet).Name("users.location")
+	s.AddRoute("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, nil)
+	}, http.MethodGet).Name("users.get")
+
+	send := func(path string, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := send("/users/1/locations", "10.0.0.1")
+	assert.Equal(t, first.Code, http.StatusOK)
+	assert.Equal(t, first.Header().Get("X-RateLimit-Limit"), "2")
+	assert.Equal(t, first.Header().Get("X-RateLimit-Remaining"), "1")
+
+	assert.Equal(t, send("/users/1/locations", "10.0.0.1").Code, http.StatusOK)
+
+	throttled := send("/users/1/locations", "10.0.0.1")
+	assert.Equal(t, throttled.Code, http.StatusTooManyRequests)
+	assert.Equal(t, throttled.Header().Get("Retry-After"), "2")
+	assert.Contains(t, throttled.Body.String(), ErrorCodeRateLimited)
+
+	assert.Equal(t, send("/users/1/locations", "10.0.0.2").Code, http.StatusOK)
+	assert.Equal(t, send("/users/1", "10.0.0.1").Code, http.StatusOK)
+}
+
+func TestIPRateLimiter(t *testing.T) {
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Secret: "secret"})
+	assert.Nil(t, err)
+
+	s := New(&Config{Port: 8080})
+	s.Use(IPRateLimiter(NewMemoryRateLimitStore(), RateLimit{Rate: 0.5, Burst: 2}))
+	s.Use(s.Authentication(authenticator))
+	s.AddRoute("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, nil)
+	}, http.MethodGet)
+
+	send := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("Authorization", "Bearer guessed")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the failed authentications are counted, so guessing credentials is throttled
+	assert.Equal(t, send("10.0.0.1").Code, http.StatusUnauthorized)
+	assert.Equal(t, send("10.0.0.1").Code, http.StatusUnauthorized)
+	throttled := send("10.0.0.1")
+	assert.Equal(t, throttled.Code, http.StatusTooManyRequests)
+	assert.Contains(t, throttled.Body.String(), ErrorCodeRateLimited)
+
+	assert.Equal(t, send("10.0.0.2").Code, http.StatusUnauthorized)
+}
+
+func TestMemoryRateLimitStore_Refill(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Now()
+
+	result, _ := store.Take("key", limit, now)
+	assert.True(t, result.Allowed)
+
+	result, _ = store.Take("key", limit, now.Add(500*time.Millisecond))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, result.RetryAfter, 500*time.Millisecond)
+
+	result, _ = store.Take("key", limit, now.Add(time.Second))
+	assert.True(t, result.Allowed)
+}
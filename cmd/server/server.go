@@ -13,50 +13,130 @@ import (
 )
 
 type Config struct {
-	Port    int    `json:"port"`
-	Version string `json:"version"`
+	Port    int       `json:"port"`
+	Version string    `json:"version"`
+	TLS     TLSConfig `json:"tls"`
+
+	// timeouts of the http.Server, zero read and write timeouts take 15s and 60s
+	ReadTimeout       time.Duration `json:"read_timeout"`
+	ReadHeaderTimeout time.Duration `json:"read_header_timeout"`
+	WriteTimeout      time.Duration `json:"write_timeout"`
+	IdleTimeout       time.Duration `json:"idle_timeout"`
+	MaxHeaderBytes    int           `json:"max_header_bytes"`
+
+	// HandlerTimeout and MaxBodyBytes apply to every route, 10s and 1MB when zero, and Routes
+	// overrides them by route name. The write timeout still bounds a longer handler timeout.
+	HandlerTimeout time.Duration          `json:"handler_timeout"`
+	MaxBodyBytes   int64                  `json:"max_body_bytes"`
+	Routes         map[string]RouteConfig `json:"routes"`
 }
 
 type Server struct {
-	httpSrv *http.Server
-	router  *mux.Router
-	cfg     *Config
-	Version string
+	httpSrv  *http.Server
+	router   *mux.Router
+	handler  http.Handler
+	cfg      *Config
+	routes   map[*mux.Route]*Route
+	versions []*APIVersion
+	// authenticators describe the security schemes of the OpenAPI document
+	authenticators []Authenticator
+	Version        string
+}
+
+// Route holds the options of a route added to the server.
+type Route struct {
+	route   *mux.Route
+	version *APIVersion
+	name    string
+	public  bool
+	scopes  []string
+	doc     *Doc
+}
+
+// Name identifies the route in the configuration, e.g. in its rate limit.
+func (r *Route) Name(name string) *Route {
+	r.name = name
+	return r
+}
+
+// Public lets the route be called without credentials.
+func (r *Route) Public() *Route {
+	r.public = true
+	return r
+}
+
+// Scopes are the scopes the caller needs, all of them, to call the route.
+func (r *Route) Scopes(scopes ...string) *Route {
+	r.scopes = append(r.scopes, scopes...)
+	return r
 }
 
 func New(c *Config) *Server {
+	cfg := c.withDefaults()
 	r := mux.NewRouter()
-	return &Server{
-		cfg: c,
+	s := &Server{
+		cfg: &cfg,
 		httpSrv: &http.Server{
-			Handler:      r,
-			Addr:         fmt.Sprintf(":%d", c.Port),
-			WriteTimeout: 60 * time.Second,
-			ReadTimeout:  15 * time.Second,
+			Addr:              fmt.Sprintf(":%d", cfg.Port),
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
 		},
 		router:  r,
-		Version: c.Version,
+		handler: r,
+		routes:  make(map[*mux.Route]*Route),
+		Version: cfg.Version,
 	}
+	s.httpSrv.Handler = s
+	r.Use(s.limitBody, s.versionHeaders)
+	return s
 }
 
-func (s *Server) AddRoute(path string, h http.HandlerFunc, methods ...string) {
+func (s *Server) AddRoute(path string, h http.HandlerFunc, methods ...string) *Route {
 
 	r := handlers.RecoveryHandler(handlers.PrintRecoveryStack(true))(h)
 
-	g := http.TimeoutHandler(r, time.Duration(10)*time.Second, "response timeout exceeded")
+	route := &Route{}
+	route.route = s.router.Handle(path, s.timeout(route, r)).Methods(methods...)
+	s.routes[route.route] = route
 
-	s.router.Handle(path, g).Methods(methods...)
+	return route
 }
 
+// currentRoute returns the options of the route the request matched.
+func (s *Server) currentRoute(r *http.Request) *Route {
+	return s.routes[mux.CurrentRoute(r)]
+}
+
+// Use adds middlewares that run for every matched route.
+func (s *Server) Use(middlewares ...mux.MiddlewareFunc) {
+	s.router.Use(middlewares...)
+}
+
+// ListenAndServe serves https when the tls files are configured, plain http otherwise.
 func (s *Server) ListenAndServe() {
-	if err := s.httpSrv.ListenAndServe(); err != nil {
+	if !s.cfg.TLS.enabled() {
+		if err := s.httpSrv.ListenAndServe(); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	reloader, err := newCertReloader(s.cfg.TLS)
+	if err != nil {
 		panic(err)
 	}
+	s.httpSrv.TLSConfig = reloader.tlsConfig()
 
+	if err := s.httpSrv.ListenAndServeTLS("", ""); err != nil {
+		panic(err)
+	}
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
+	s.handler.ServeHTTP(w, r)
 }
 
 func Render(w http.ResponseWriter, r *http.Request, obj interface{}, status int) {
@@ -100,6 +180,36 @@ func BadRequest(w http.ResponseWriter, r *http.Request, code string, messages ..
 	Render(w, r, err, http.StatusBadRequest)
 }
 
+// MovedPermanently redirects the client to the location, which must be absolute or relative
+// to the host. The query of the request is kept.
+func MovedPermanently(w http.ResponseWriter, r *http.Request, location string, messages ...string) {
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", location)
+
+	Render(w, r, &errorResponse{
+		Code:     "MOVED_PERMANENTLY",
+		Messages: messages,
+	}, http.StatusMovedPermanently)
+}
+
+func Forbidden(w http.ResponseWriter, r *http.Request, code string, messages ...string) {
+	err := &errorResponse{
+		Code:     code,
+		Messages: messages,
+	}
+	Render(w, r, err, http.StatusForbidden)
+}
+
+func Conflict(w http.ResponseWriter, r *http.Request, code string, messages ...string) {
+	err := &errorResponse{
+		Code:     code,
+		Messages: messages,
+	}
+	Render(w, r, err, http.StatusConflict)
+}
+
 func NotFound(w http.ResponseWriter, r *http.Request, messages ...string) {
 	err := &errorResponse{
 		Code:     "NOT_FOUND",
@@ -142,6 +252,24 @@ func GetStringParam(r *http.Request, key string, defaultValue string) string {
 	return keys[0]
 }
 
+// AddLink appends an RFC 8288 Link header that points to the request URL with the given query
+// params replaced. Params with an empty value are removed from the link.
+func AddLink(w http.ResponseWriter, r *http.Request, rel string, params map[string]string) {
+	link := *r.URL
+	query := link.Query()
+
+	for key, value := range params {
+		if value == "" {
+			query.Del(key)
+			continue
+		}
+		query.Set(key, value)
+	}
+	link.RawQuery = query.Encode()
+
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="%s"`, link.RequestURI(), rel))
+}
+
 func GetIntFromPath(r *http.Request, key string) (int, error) {
 	str := mux.Vars(r)[key]
 	val, err := strconv.ParseInt(str, 10, 64)
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	TenantHeader = "X-Tenant-ID"
+	TenantClaim  = "tenant_id"
+	// ScopeAnyTenant lets a caller without tenant_id claim pick the tenant with the header
+	ScopeAnyTenant = "tenants:any"
+
+	ErrorCodeTenantRequired string = "TENANT_REQUIRED"
+	ErrorCodeTenantInvalid  string = "TENANT_INVALID"
+	ErrorCodeTenantMismatch string = "TENANT_MISMATCH"
+
+	tenantKey contextKey = "tenant"
+)
+
+var tenantPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// TenancyConfig sets who the Tenancy middleware lets pick the tenant.
+type TenancyConfig struct {
+	// DefaultTenant is the tenant of the callers without one, e.g. in a single tenant deployment;
+	// empty requires the callers to have one
+	DefaultTenant string
+	// AnyTenantSubjects are the subjects of the client certificates trusted with every tenant,
+	// e.g. "CN=billing,O=acme", as if they had the tenants:any scope
+	AnyTenantSubjects []string
+}
+
+// Tenancy puts the tenant of the request in its context. The tenant_id claim of the caller
+// wins and a header naming another tenant is rejected. The X-Tenant-ID header is only read for
+// callers trusted with every tenant: the ones with the tenants:any scope or a client certificate
+// of the AnyTenantSubjects, a certificate signed by the client CA is not enough. Other callers
+// without tenant get the default one, a 403 when it is empty, so a token issued without tenant
+// can't pick one. Public routes don't need a tenant.
+func (s *Server) Tenancy(c TenancyConfig) mux.MiddlewareFunc {
+	defaultTenant := c.DefaultTenant
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(TenantHeader)
+			claims := GetClaims(r.Context())
+			claim, _ := claims[TenantClaim].(string)
+			anyTenant := claims.HasScope(ScopeAnyTenant) || (ClientCertificate(r) != nil && contains(c.AnyTenantSubjects, ClientSubject(r)))
+
+			if claim != "" && header != "" && header != claim {
+				Forbidden(w, r, ErrorCodeTenantMismatch, fmt.Sprintf("the caller belongs to tenant %s", claim))
+				return
+			}
+
+			tenant := claim
+			if tenant == "" {
+				tenant = defaultTenant
+				if anyTenant && header != "" {
+					tenant = header
+				}
+			}
+
+			if route := s.currentRoute(r); route != nil && route.public {
+				if tenant != "" && tenantPattern.MatchString(tenant) {
+					r = r.WithContext(WithTenant(r.Context(), tenant))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			switch {
+			case claim == "" && !anyTenant && header != "" && header != defaultTenant:
+				Forbidden(w, r, ErrorCodeTenantRequired, fmt.Sprintf("the caller has no %s claim, the %s header needs the %s scope", TenantClaim, TenantHeader, ScopeAnyTenant))
+				return
+			case tenant == "" && anyTenant:
+				BadRequest(w, r, ErrorCodeTenantRequired, fmt.Sprintf("the %s header is mandatory", TenantHeader))
+				return
+			case tenant == "":
+				Forbidden(w, r, ErrorCodeTenantRequired, fmt.Sprintf("the caller has no %s claim", TenantClaim))
+				return
+			}
+
+			if !tenantPattern.MatchString(tenant) {
+				BadRequest(w, r, ErrorCodeTenantInvalid, "the tenant must have up to 64 letters, digits, - or _")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+// WithTenant returns the context with the tenant, for work done outside of a request.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// GetTenant returns the tenant of the request, empty when it has none.
+func GetTenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey).(string)
+	return tenant
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenancy(t *testing.T) {
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Secret: "secret"})
+	assert.Nil(t, err)
+
+	s := New(&Config{Port: 8080})
+	s.Use(s.Authentication(authenticator))
+	s.Use(s.Tenancy(TenancyConfig{}))
+	s.AddRoute("/users", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, map[string]string{"tenant": GetTenant(r.Context())})
+	}, http.MethodGet)
+
+	send := func(claims Claims, header string) *httptest.ResponseRecorder {
+		claims["exp"] = time.Now().Unix() + 60
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+signHS256("secret", claims))
+		if header != "" {
+			req.Header.Set(TenantHeader, header)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	fromClaim := send(Claims{"sub": "jhon", TenantClaim: "acme"}, "")
+	assert.Equal(t, fromClaim.Code, http.StatusOK)
+	assert.Contains(t, fromClaim.Body.String(), `"tenant":"acme"`)
+
+	fromHeader := send(Claims{"sub": "service", "scope": ScopeAnyTenant}, "globex")
+	assert.Contains(t, fromHeader.Body.String(), `"tenant":"globex"`)
+
+	// a token issued without tenant can't pick one
+	tenantless := send(Claims{"sub": "jhon"}, "globex")
+	assert.Equal(t, tenantless.Code, http.StatusForbidden)
+	assert.Contains(t, tenantless.Body.String(), ErrorCodeTenantRequired)
+
+	tenantless = send(Claims{"sub": "jhon"}, "")
+	assert.Equal(t, tenantless.Code, http.StatusForbidden)
+	assert.Contains(t, tenantless.Body.String(), ErrorCodeTenantRequired)
+
+	mismatch := send(Claims{"sub": "jhon", TenantClaim: "acme"}, "globex")
+	assert.Equal(t, mismatch.Code, http.StatusForbidden)
+	assert.Contains(t, mismatch.Body.String(), ErrorCodeTenantMismatch)
+
+	missing := send(Claims{"sub": "service", "scope": ScopeAnyTenant}, "")
+	assert.Equal(t, missing.Code, http.StatusBadRequest)
+	assert.Contains(t, missing.Body.String(), ErrorCodeTenantRequired)
+
+	invalid := send(Claims{"sub": "service", "scope": ScopeAnyTenant}, "acme.clients")
+	assert.Equal(t, invalid.Code, http.StatusBadRequest)
+	assert.Contains(t, invalid.Body.String(), ErrorCodeTenantInvalid)
+}
+
+func TestTenancyDefault(t *testing.T) {
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Secret: "secret"})
+	assert.Nil(t, err)
+
+	s := New(&Config{Port: 8080})
+	s.Use(s.Authentication(authenticator))
+	s.Use(s.Tenancy(TenancyConfig{DefaultTenant: "default"}))
+	s.AddRoute("/users", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, map[string]string{"tenant": GetTenant(r.Context())})
+	}, http.MethodGet)
+
+	send := func(header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+signHS256("secret", Claims{"sub": "jhon", "exp": time.Now().Unix() + 60}))
+		if header != "" {
+			req.Header.Set(TenantHeader, header)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// a single tenant deployment serves the callers without tenant from the default one
+	assert.Contains(t, send("").Body.String(), `"tenant":"default"`)
+	assert.Contains(t, send("default").Body.String(), `"tenant":"default"`)
+	assert.Equal(t, send("globex").Code, http.StatusForbidden)
+}
+
+func TestTenancyClientCertificate(t *testing.T) {
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Secret: "secret"})
+	assert.Nil(t, err)
+
+	s := New(&Config{Port: 8080})
+	s.Use(s.Authentication(authenticator))
+	s.Use(s.Tenancy(TenancyConfig{AnyTenantSubjects: []string{"CN=billing,O=acme"}}))
+	s.AddRoute("/users", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, map[string]string{"tenant": GetTenant(r.Context())})
+	}, http.MethodGet)
+
+	send := func(subject pkix.Name) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Authorization", "Bearer "+signHS256("secret", Claims{"sub": "service", "exp": time.Now().Unix() + 60}))
+		req.Header.Set(TenantHeader, "globex")
+		// a certificate verified against the client CA
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	trusted := send(pkix.Name{CommonName: "billing", Organization: []string{"acme"}})
+	assert.Equal(t, trusted.Code, http.StatusOK)
+	assert.Contains(t, trusted.Body.String(), `"tenant":"globex"`)
+
+	// any other certificate of the client CA can't pick the tenant
+	other := send(pkix.Name{CommonName: "reports", Organization: []string{"acme"}})
+	assert.Equal(t, other.Code, http.StatusForbidden)
+	assert.Contains(t, other.Body.String(), ErrorCodeTenantRequired)
+}
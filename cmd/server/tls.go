@@ -0,0 +1,191 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TLSConfig enables https when CertFile and KeyFile are set. With ClientCAFile the client
+// certificates are verified against that bundle, and required with ClientCertRequired. The files
+// are checked every ReloadInterval and reloaded when they change, so they can be rotated on disk
+// without a restart.
+type TLSConfig struct {
+	CertFile           string        `json:"cert_file"`
+	KeyFile            string        `json:"key_file"`
+	ClientCAFile       string        `json:"client_ca_file"`
+	ClientCertRequired bool          `json:"client_cert_required"`
+	ReloadInterval     time.Duration `json:"reload_interval"`
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// certReloader keeps the tls configuration of the files, which it reloads on the handshakes
+// when they have changed. A failed reload keeps the previous configuration, the files may be
+// half rotated, and is retried on the next check.
+type certReloader struct {
+	cfg TLSConfig
+
+	mu       sync.Mutex
+	config   *tls.Config
+	modTimes []time.Time
+	checked  time.Time
+}
+
+func newCertReloader(c TLSConfig) (*certReloader, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls needs both a cert file and a key file")
+	}
+	if c.ClientCertRequired && c.ClientCAFile == "" {
+		return nil, errors.New("tls client certificates can't be required without a client ca file")
+	}
+
+	r := &certReloader{cfg: c, checked: time.Now()}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// alpnProtocols are the protocols the server offers. The config of a handshake replaces the one
+// of the server, where http.Server adds them, so every reloaded config must offer them too or
+// HTTP/2 is off.
+var alpnProtocols = []string{"h2", "http/1.1"}
+
+// tlsConfig is the configuration of the server, every handshake takes the current one.
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: alpnProtocols,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+		// only checked by http.Server to know there is a certificate
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.current().Certificates[0], nil
+		},
+	}
+}
+
+func (r *certReloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checked) < r.cfg.ReloadInterval {
+		return r.config
+	}
+	r.checked = now
+
+	modTimes, err := modTimes(r.files())
+	if err == nil && equalTimes(modTimes, r.modTimes) {
+		return r.config
+	}
+	if err == nil {
+		err = r.load()
+	}
+	if err != nil {
+		logrus.Errorf("error reloading tls certificates, keeping the previous ones: %v", err)
+		return r.config
+	}
+
+	logrus.Infof("tls certificates reloaded from %s", r.cfg.CertFile)
+	return r.config
+}
+
+func (r *certReloader) load() error {
+	modTimes, err := modTimes(r.files())
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("error loading tls certificate: %v", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   alpnProtocols,
+	}
+
+	if r.cfg.ClientCAFile != "" {
+		bundle, err := ioutil.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("error reading client ca file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("no certificates in client ca file %s", r.cfg.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.cfg.ClientCertRequired {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	r.config, r.modTimes = config, modTimes
+	return nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func modTimes(files []string) ([]time.Time, error) {
+	times := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		times[i] = info.ModTime()
+	}
+	return times, nil
+}
+
+func equalTimes(a []time.Time, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ClientCertificate returns the verified certificate the client sent over mutual TLS, nil
+// without one.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// ClientSubject returns the subject of the client certificate, e.g. "CN=billing,O=acme", which
+// identifies the calling service. It is empty without a verified certificate.
+func ClientSubject(r *http.Request) string {
+	if cert := ClientCertificate(r); cert != nil {
+		return cert.Subject.String()
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCert(t *testing.T, name string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"acme"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (c *testCert) write(t *testing.T, certFile string, keyFile string) {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(certFile, c.pem, 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "users-api ca", 1, nil)
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	newTestCert(t, "users-api", 2, ca).write(t, certFile, keyFile)
+	assert.Nil(t, ioutil.WriteFile(caFile, ca.pem, 0600))
+
+	reloader, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientCertRequired: true})
+	assert.Nil(t, err)
+
+	s := New(&Config{Port: 8080})
+	s.AddRoute("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, map[string]string{"subject": ClientSubject(r)})
+	}, http.MethodGet)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go http.Serve(tls.NewListener(ln, reloader.tlsConfig()), s)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certificates ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certificates},
+			DisableKeepAlives: true,
+		}}
+		return client.Get("https://" + ln.Addr().String() + "/whoami")
+	}
+
+	res, err := get(newTestCert(t, "billing", 3, ca).tlsCertificate())
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Contains(t, string(body), `"subject":"CN=billing,O=acme"`)
+	assert.Equal(t, res.TLS.PeerCertificates[0].SerialNumber.Int64(), int64(2))
+
+	_, err = get()
+	assert.NotNil(t, err, "client certificate is required")
+
+	_, err = get(newTestCert(t, "billing", 4, newTestCert(t, "other ca", 5, nil)).tlsCertificate())
+	assert.NotNil(t, err, "client certificate of another ca")
+
+	newTestCert(t, "users-api", 6, ca).write(t, certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, later, later))
+
+	res, err = get(newTestCert(t, "billing", 7, ca).tlsCertificate())
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, res.TLS.PeerCertificates[0].SerialNumber.Int64(), int64(6), "rotated certificate is served")
+}
+
+func TestTLSHTTP2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "users-api ca", 1, nil)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	newTestCert(t, "users-api", 2, ca).write(t, certFile, keyFile)
+
+	reloader, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	assert.Nil(t, err)
+
+	// served like ListenAndServe does, http.Server sets up HTTP/2 on its config
+	srv := &http.Server{Handler: New(&Config{Port: 8080}), TLSConfig: reloader.tlsConfig()}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots, NextProtos: []string{"h2", "http/1.1"}})
+	assert.Nil(t, err)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	assert.Equal(t, conn.ConnectionState().NegotiatedProtocol, "h2")
+}
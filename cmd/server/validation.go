@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const ErrorCodeInvalidParams string = "INVALID_PARAMS"
+
+// ValidationConfig sets what the Validation middleware checks besides the requests.
+type ValidationConfig struct {
+	// Responses validates the responses too, it is meant for tests
+	Responses bool
+	// InvalidResponse gets the problems of an invalid response, they are logged when nil
+	InvalidResponse func(r *http.Request, problems []string)
+}
+
+// Validation checks the path params, query params, headers and JSON body of the requests against
+// the OpenAPI document of their route, an invalid request gets a 400 listing every problem. It
+// must run before the handlers read the body. Routes without doc are not validated, nor are the
+// bodies that are not JSON, which are left for the handler to stream.
+func (s *Server) Validation(c ValidationConfig) mux.MiddlewareFunc {
+	var once sync.Once
+	var doc *OpenAPI
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// built on the first request, once every route is added
+			once.Do(func() { doc = s.OpenAPI(OpenAPIInfo{}) })
+
+			operation := doc.operation(s.currentRoute(r), r.Method)
+			if operation == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			v := &validator{components: doc.Components.Schemas, strict: true}
+			if err := v.request(r, operation); err != nil {
+				BodyError(w, r, ErrorCodeInvalidParams, err)
+				return
+			}
+			if len(v.problems) > 0 {
+				BadRequest(w, r, ErrorCodeInvalidParams, v.problems...)
+				return
+			}
+
+			if !c.Responses {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			v = &validator{components: doc.Components.Schemas}
+			v.response(recorder, operation)
+			if len(v.problems) == 0 {
+				return
+			}
+			if c.InvalidResponse != nil {
+				c.InvalidResponse(r, v.problems)
+				return
+			}
+			logrus.Errorf("invalid response to %s %s: %s", r.Method, r.URL.Path, strings.Join(v.problems, ", "))
+		})
+	}
+}
+
+// operation finds the operation of the route and method in the document.
+func (doc *OpenAPI) operation(route *Route, method string) *Operation {
+	if route == nil || route.doc == nil {
+		return nil
+	}
+	template, err := route.route.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+	return doc.Paths[openAPIPath(template)][strings.ToLower(method)]
+}
+
+// validator collects the problems of a request or response. Strict validation, the one of the
+// requests, rejects unknown properties and checks the required ones.
+type validator struct {
+	components map[string]*Schema
+	strict     bool
+	problems   []string
+}
+
+func (v *validator) problem(at string, format string, args ...interface{}) {
+	v.problems = append(v.problems, at+": "+fmt.Sprintf(format, args...))
+}
+
+// request validates the params and body of the request, it only returns the errors of reading
+// the body, the problems are collected.
+func (v *validator) request(r *http.Request, operation *Operation) error {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	for _, param := range operation.Parameters {
+		var value string
+		var present bool
+		switch param.In {
+		case "path":
+			value, present = vars[param.Name]
+		case "query":
+			values, ok := query[param.Name]
+			present = ok && len(values) > 0 && values[0] != ""
+			if present {
+				value = values[0]
+			}
+		case "header":
+			value = r.Header.Get(param.Name)
+			present = value != ""
+		}
+
+		at := param.In + "." + param.Name
+		if !present {
+			if param.Required {
+				v.problem(at, "is required")
+			}
+			continue
+		}
+		v.param(at, param.Schema, value)
+	}
+
+	if operation.RequestBody == nil {
+		return nil
+	}
+	schema, ok := operation.RequestBody.Content["application/json"]
+	if !ok {
+		return nil
+	}
+
+	if err := checkContentType(r); err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if operation.RequestBody.Required {
+			v.problem("body", "is required")
+		}
+		return nil
+	}
+
+	value, err := decodeValue(body)
+	if err != nil {
+		v.problem("body", "%v", err)
+		return nil
+	}
+	v.value("body", schema.Schema, value)
+	return nil
+}
+
+// response validates the body of a JSON response against the schema of its status.
+func (v *validator) response(recorder *responseRecorder, operation *Operation) {
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+		return
+	}
+
+	response, ok := operation.Responses[strconv.Itoa(recorder.status)]
+	if !ok {
+		if response, ok = operation.Responses["default"]; !ok {
+			v.problem("status", "%d is not documented", recorder.status)
+			return
+		}
+	}
+
+	body := bytes.TrimSpace(recorder.body.Bytes())
+	if response.Content == nil {
+		if len(body) > 0 {
+			v.problem("body", "must be empty for status %d", recorder.status)
+		}
+		return
+	}
+	if len(body) == 0 {
+		return
+	}
+
+	value, err := decodeValue(body)
+	if err != nil {
+		v.problem("body", "%v", err)
+		return
+	}
+	v.value("body", response.Content["application/json"].Schema, value)
+}
+
+// param validates a path, query or header value, which is always a string, against its schema.
+func (v *validator) param(at string, schema *Schema, value string) {
+	if len(schema.Enum) > 0 && !contains(schema.Enum, value) {
+		v.problem(at, "must be one of %s", strings.Join(schema.Enum, ", "))
+		return
+	}
+
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			v.problem(at, "must be an integer")
+			return
+		}
+		v.bounds(at, schema, float64(n))
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			v.problem(at, "must be a number")
+			return
+		}
+		v.bounds(at, schema, n)
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			v.problem(at, "must be true or false")
+		}
+	default:
+		v.value(at, schema, value)
+	}
+}
+
+// value validates a decoded JSON value against the schema.
+func (v *validator) value(at string, schema *Schema, value interface{}) {
+	if schema == nil {
+		return
+	}
+	if value == nil {
+		// a reference can't be marked nullable, pointers to structs are references too
+		if schema.Ref == "" && !schema.Nullable && schema.Type != "" {
+			v.problem(at, "must not be null")
+		}
+		return
+	}
+	if schema.Ref != "" {
+		v.value(at, v.components[strings.TrimPrefix(schema.Ref, "#/components/schemas/")], value)
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.problem(at, "must be an object")
+			return
+		}
+		v.object(at, schema, object)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			v.problem(at, "must be an array")
+			return
+		}
+		if schema.MinItems != nil && len(array) < *schema.MinItems {
+			v.problem(at, "must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(array) > *schema.MaxItems {
+			v.problem(at, "must have at most %d items", *schema.MaxItems)
+		}
+		for i, item := range array {
+			v.value(fmt.Sprintf("%s[%d]", at, i), schema.Items, item)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			v.problem(at, "must be a string")
+			return
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, str) {
+			v.problem(at, "must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+		length := utf8.RuneCountInString(str)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			v.problem(at, "must have at least %d characters", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			v.problem(at, "must have at most %d characters", *schema.MaxLength)
+		}
+		if _, err := time.Parse(time.RFC3339, str); schema.Format == "date-time" && err != nil {
+			v.problem(at, "must be an RFC 3339 date time")
+		}
+	case "integer":
+		number, ok := value.(json.Number)
+		n, err := number.Int64()
+		if !ok || err != nil {
+			v.problem(at, "must be an integer")
+			return
+		}
+		v.bounds(at, schema, float64(n))
+	case "number":
+		number, ok := value.(json.Number)
+		n, err := number.Float64()
+		if !ok || err != nil {
+			v.problem(at, "must be a number")
+			return
+		}
+		v.bounds(at, schema, n)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.problem(at, "must be true or false")
+		}
+	}
+}
+
+// bounds checks an integer or number against the minimum and maximum of its schema.
+func (v *validator) bounds(at string, schema *Schema, n float64) {
+	if schema.Minimum != nil && n < float64(*schema.Minimum) {
+		v.problem(at, "must be at least %d", *schema.Minimum)
+	}
+	if schema.Maximum != nil && n > float64(*schema.Maximum) {
+		v.problem(at, "must be at most %d", *schema.Maximum)
+	}
+}
+
+func (v *validator) object(at string, schema *Schema, object map[string]interface{}) {
+	if v.strict {
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				v.problem(at+"."+name, "is required")
+			}
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		switch {
+		case ok:
+		case schema.AdditionalProperties != nil:
+			property = schema.AdditionalProperties
+		case v.strict && len(schema.Properties) > 0:
+			v.problem(at+"."+name, "is not a known field")
+			continue
+		default:
+			continue
+		}
+		v.value(at+"."+name, property, object[name])
+	}
+}
+
+// decodeValue decodes a single JSON value keeping the numbers as json.Number.
+func decodeValue(body []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("must hold a single JSON value")
+	}
+	return value, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
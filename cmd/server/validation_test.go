@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidation(t *testing.T) {
+	var invalid []string
+
+	minSize, maxSize := 1, 100
+
+	s := New(&Config{Port: 8080})
+	s.Use(s.Validation(ValidationConfig{
+		Responses:       true,
+		InvalidResponse: func(r *http.Request, problems []string) { invalid = problems },
+	}))
+
+	s.AddRoute("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("broken") == "true" {
+			OK(w, r, map[string]interface{}{"id": "1", "name": "Jhon", "tags": []string{"a"}})
+			return
+		}
+		OK(w, r, testUser{Id: 1, Name: "Jhon", Tags: []string{"a"}})
+	}, http.MethodGet).Doc(Doc{
+		Params: []Param{
+			{Name: "id", In: "path", Type: "integer"},
+			{Name: "size", Type: "integer", Minimum: &minSize, Maximum: &maxSize},
+			{Name: "broken", Type: "boolean"},
+		},
+		Responses: map[int]interface{}{http.StatusOK: testUser{}},
+	})
+	s.AddRoute("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}, http.MethodPost).Doc(Doc{
+		Params:    []Param{{Name: "q", Required: true}},
+		Request:   testUser{},
+		Responses: map[int]interface{}{http.StatusCreated: nil},
+	})
+	s.AddRoute("/files", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		OK(w, r, map[string]int{"bytes": len(body)})
+	}, http.MethodPost).Doc(Doc{
+		Params:    []Param{{Name: "dry_run", Type: "boolean"}},
+		Consumes:  []string{"text/csv"},
+		Responses: map[int]interface{}{http.StatusOK: map[string]int{}},
+	})
+	s.AddRoute("/undocumented/{id}", func(w http.ResponseWriter, r *http.Request) {}, http.MethodGet)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		contentType string
+		status      int
+		messages    []string
+	}{
+		{
+			name:   "valid params",
+			method: http.MethodGet,
+			path:   "/users/1?size=10",
+			status: http.StatusOK,
+		},
+		{
+			name:     "invalid path and query params",
+			method:   http.MethodGet,
+			path:     "/users/abc?size=ten&broken=maybe",
+			status:   http.StatusBadRequest,
+			messages: []string{"path.id: must be an integer", "query.size: must be an integer", "query.broken: must be true or false"},
+		},
+		{
+			name:     "param out of bounds",
+			method:   http.MethodGet,
+			path:     "/users/1?size=-1",
+			status:   http.StatusBadRequest,
+			messages: []string{"query.size: must be at least 1"},
+		},
+		{
+			name:     "param above the maximum",
+			method:   http.MethodGet,
+			path:     "/users/1?size=100000000",
+			status:   http.StatusBadRequest,
+			messages: []string{"query.size: must be at most 100"},
+		},
+		{
+			name:   "undocumented route",
+			method: http.MethodGet,
+			path:   "/undocumented/abc",
+			status: http.StatusOK,
+		},
+		{
+			name:   "valid body",
+			method: http.MethodPost,
+			path:   "/users?q=a",
+			body:   `{"name":"Jhon","tags":["a"],"address":{"street":"Main"}}`,
+			status: http.StatusCreated,
+		},
+		{
+			name:     "missing required param",
+			method:   http.MethodPost,
+			path:     "/users",
+			body:     `{"name":"Jhon","tags":["a"]}`,
+			status:   http.StatusBadRequest,
+			messages: []string{"query.q: is required"},
+		},
+		{
+			name:   "invalid body",
+			method: http.MethodPost,
+			path:   "/users?q=a",
+			body:   `{"name":"Jo","tags":[],"address":{"street":1},"age":30}`,
+			status: http.StatusBadRequest,
+			messages: []string{
+				"body.address.street: must be a string",
+				"body.age: is not a known field",
+				"body.name: must have at least 3 characters",
+				"body.tags: must have at least 1 items",
+			},
+		},
+		{
+			name:     "missing required field",
+			method:   http.MethodPost,
+			path:     "/users?q=a",
+			body:     `{"tags":["a"]}`,
+			status:   http.StatusBadRequest,
+			messages: []string{"body.name: is required"},
+		},
+		{
+			name:     "empty body",
+			method:   http.MethodPost,
+			path:     "/users?q=a",
+			status:   http.StatusBadRequest,
+			messages: []string{"body: is required"},
+		},
+		{
+			name:        "not json",
+			method:      http.MethodPost,
+			path:        "/users?q=a",
+			body:        `name=Jhon`,
+			contentType: "application/x-www-form-urlencoded",
+			status:      http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "body that is not json left to the handler",
+			method:      http.MethodPost,
+			path:        "/files?dry_run=true",
+			body:        "name\nJhon\n",
+			contentType: "text/csv",
+			status:      http.StatusOK,
+			messages:    []string{`"bytes":10`},
+		},
+		{
+			name:        "params of a route with a body that is not json",
+			method:      http.MethodPost,
+			path:        "/files?dry_run=yes",
+			contentType: "text/csv",
+			status:      http.StatusBadRequest,
+			messages:    []string{"query.dry_run: must be true or false"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invalid = nil
+
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType == "" {
+				tt.contentType = "application/json"
+			}
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			assert.Equal(t, w.Code, tt.status)
+			for _, message := range tt.messages {
+				assert.Contains(t, w.Body.String(), message)
+			}
+			assert.Empty(t, invalid)
+		})
+	}
+
+	t.Run("invalid response", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1?broken=true", nil))
+
+		assert.Equal(t, w.Code, http.StatusOK)
+		assert.Equal(t, invalid, []string{"body.id: must be an integer"})
+	})
+}
+
+func TestValidationBounds(t *testing.T) {
+	schema := &Schema{Type: "integer"}
+	validate(schema, "required,gte=0,max=10")
+	number := &Schema{Type: "number"}
+	validate(number, "min=1")
+
+	v := &validator{}
+	v.value("body.size", schema, json.Number("11"))
+	v.value("body.offset", schema, json.Number("-1"))
+	v.value("body.valid", schema, json.Number("10"))
+	v.value("body.ratio", number, json.Number("0.5"))
+
+	assert.Equal(t, v.problems, []string{
+		"body.size: must be at most 10",
+		"body.offset: must be at least 0",
+		"body.ratio: must be at least 1",
+	})
+}
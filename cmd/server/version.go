@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	VersionSupported  string = "supported"
+	VersionDeprecated string = "deprecated"
+)
+
+// APIVersion is a version of the api, its routes are served under /v<name>. Each version
+// registers its own routes, so versions can have different handlers and bodies, and a path
+// under a version that was not registered gets a 404.
+type APIVersion struct {
+	server      *Server
+	name        string
+	deprecation *time.Time
+	sunset      *time.Time
+}
+
+// VersionInfo describes a version in the /versions listing.
+type VersionInfo struct {
+	Version      string     `json:"version"`
+	Path         string     `json:"path"`
+	Status       string     `json:"status"`
+	DeprecatedAt *time.Time `json:"deprecated_at,omitempty"`
+	SunsetAt     *time.Time `json:"sunset_at,omitempty"`
+}
+
+type VersionList struct {
+	Data []VersionInfo `json:"data"`
+}
+
+// APIVersion returns the version with the given name, e.g. "1", adding it the first time.
+func (s *Server) APIVersion(name string) *APIVersion {
+	for _, v := range s.versions {
+		if v.name == name {
+			return v
+		}
+	}
+
+	v := &APIVersion{server: s, name: name}
+	s.versions = append(s.versions, v)
+	return v
+}
+
+// AddRoute adds a route under the path of the version.
+func (v *APIVersion) AddRoute(path string, h http.HandlerFunc, methods ...string) *Route {
+	route := v.server.AddRoute(v.Path()+path, h, methods...)
+	route.version = v
+	return route
+}
+
+func (v *APIVersion) Path() string {
+	return "/v" + v.name
+}
+
+// Deprecated marks the version as deprecated since the given time, its responses get a
+// Deprecation header from then on.
+func (v *APIVersion) Deprecated(at time.Time) *APIVersion {
+	v.deprecation = &at
+	return v
+}
+
+// Sunset sets when the version will be removed, its responses get a Sunset header.
+func (v *APIVersion) Sunset(at time.Time) *APIVersion {
+	v.sunset = &at
+	return v
+}
+
+func (v *APIVersion) deprecated(now time.Time) bool {
+	return v.deprecation != nil && !now.Before(*v.deprecation)
+}
+
+func (v *APIVersion) info(now time.Time) VersionInfo {
+	info := VersionInfo{
+		Version:      v.name,
+		Path:         v.Path(),
+		Status:       VersionSupported,
+		DeprecatedAt: v.deprecation,
+		SunsetAt:     v.sunset,
+	}
+	if v.deprecated(now) {
+		info.Status = VersionDeprecated
+	}
+	return info
+}
+
+// Versions lists the versions with routes, oldest first.
+func (s *Server) Versions() []VersionInfo {
+	now := time.Now()
+
+	versions := make([]VersionInfo, 0, len(s.versions))
+	for _, v := range s.versions {
+		versions = append(versions, v.info(now))
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, errA := strconv.Atoi(versions[i].Version)
+		b, errB := strconv.Atoi(versions[j].Version)
+		if errA != nil || errB != nil {
+			return versions[i].Version < versions[j].Version
+		}
+		return a < b
+	})
+	return versions
+}
+
+// versionHeaders adds the Deprecation (RFC 9745) and Sunset (RFC 8594) headers to the
+// responses of the routes of a deprecated or sunsetting version.
+func (s *Server) versionHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := s.currentRoute(r); route != nil && route.version != nil {
+			v := route.version
+			if v.deprecation != nil {
+				w.Header().Set("Deprecation", fmt.Sprintf("@%d", v.deprecation.Unix()))
+			}
+			if v.sunset != nil {
+				w.Header().Set("Sunset", v.sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIVersion(t *testing.T) {
+	s := New(&Config{Port: 8080})
+
+	deprecation := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	s.APIVersion("2").AddRoute("/users", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, map[string]string{"version": "2"})
+	}, http.MethodGet)
+	s.APIVersion("1").AddRoute("/users", func(w http.ResponseWriter, r *http.Request) {
+		OK(w, r, map[string]string{"version": "1"})
+	}, http.MethodGet)
+	s.APIVersion("1").Deprecated(deprecation).Sunset(sunset)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	v1 := get("/v1/users")
+	assert.Equal(t, v1.Code, http.StatusOK)
+	assert.Contains(t, v1.Body.String(), `"version":"1"`)
+	assert.Equal(t, v1.Header().Get("Deprecation"), "@1798761600")
+	assert.Equal(t, v1.Header().Get("Sunset"), "Thu, 01 Jul 2027 00:00:00 GMT")
+
+	v2 := get("/v2/users")
+	assert.Contains(t, v2.Body.String(), `"version":"2"`)
+	assert.Empty(t, v2.Header().Get("Deprecation"))
+
+	assert.Equal(t, get("/v999/users").Code, http.StatusNotFound)
+
+	versions := s.Versions()
+	assert.Equal(t, len(versions), 2)
+	assert.Equal(t, versions[0].Version, "1")
+	assert.Equal(t, versions[0].Path, "/v1")
+	assert.Equal(t, versions[0].SunsetAt, &sunset)
+	assert.Equal(t, versions[1].Status, VersionSupported)
+}
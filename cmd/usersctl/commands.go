@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/users-api/pkg/client"
+	"github.com/users-api/pkg/user"
+)
+
+const (
+	formatNDJSON = "ndjson"
+	formatJSON   = "json"
+	formatCSV    = "csv"
+
+	defaultListLimit = 100
+)
+
+func getCommand(fs *flag.FlagSet) func(c *cli, args []string) error {
+	fields := fs.String("fields", "", "comma separated fields to return, e.g. id,name")
+
+	return func(c *cli, args []string) error {
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+
+		u, err := c.client.Get(context.Background(), id, splitFields(*fields)...)
+		if err != nil {
+			return err
+		}
+		return render(c.out, c.output, u, usersTable([]user.User{u}))
+	}
+}
+
+func listCommand(fs *flag.FlagSet) func(c *cli, args []string) error {
+	name := fs.String("name", "", "name of the users, mandatory")
+	size := fs.Int("size", 0, "size of the pages requested")
+	limit := fs.Int("limit", defaultListLimit, "maximum number of users listed, 0 lists them all")
+	fields := fs.String("fields", "", "comma separated fields to return, e.g. id,name")
+
+	return func(c *cli, args []string) error {
+		if *name == "" || len(args) > 0 {
+			return errUsage
+		}
+
+		users := make([]user.User, 0)
+		it := c.client.Find(context.Background(), client.FindOptions{Name: *name, Size: *size, Fields: splitFields(*fields)})
+		for (*limit <= 0 || len(users) < *limit) && it.Next() {
+			users = append(users, it.User())
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+		return render(c.out, c.output, users, usersTable(users))
+	}
+}
+
+// userFlags are the fields of a user set with flags.
+type userFlags struct {
+	name    string
+	address string
+	dob     string
+	file    string
+}
+
+func (f *userFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.name, "name", "", "name of the user")
+	fs.StringVar(&f.address, "address", "", "address of the user")
+	fs.StringVar(&f.dob, "dob", "", "date of birth, 2006-01-02 or an RFC 3339 time")
+	fs.StringVar(&f.file, "file", "", "JSON file with the fields of the user, - reads stdin; the flags override its fields")
+}
+
+// patch returns the fields of the file and the flags set, ok is false when there are none.
+func (f *userFlags) patch(c *cli, fs *flag.FlagSet) (request user.PatchRequest, ok bool, err error) {
+	if f.file != "" {
+		data, err := readFile(c, f.file)
+		if err != nil {
+			return request, false, err
+		}
+		if err := json.Unmarshal(data, &request); err != nil {
+			return request, false, fmt.Errorf("can't read %s: %v", f.file, err)
+		}
+		ok = true
+	}
+
+	fs.Visit(func(flag *flag.Flag) {
+		switch flag.Name {
+		case "name":
+			request.Name, ok = &f.name, true
+		case "address":
+			request.Address, ok = &f.address, true
+		case "dob":
+			var dob time.Time
+			if dob, err = parseDate(f.dob); err == nil {
+				request.Dob, ok = &dob, true
+			}
+		}
+	})
+	return request, ok, err
+}
+
+func createCommand(fs *flag.FlagSet) func(c *cli, args []string) error {
+	var f userFlags
+	f.register(fs)
+
+	return func(c *cli, args []string) error {
+		patch, ok, err := f.patch(c, fs)
+		if err != nil {
+			return err
+		}
+		if !ok || len(args) > 0 {
+			return errUsage
+		}
+
+		var u user.User
+		patch.Apply(&u)
+		request := user.UserRequest{Name: u.Name, Address: u.Address, Dob: u.Dob}
+
+		id, err := c.client.Create(context.Background(), request)
+		if err != nil {
+			return err
+		}
+
+		created := struct {
+			Id int64 `json:"id"`
+		}{Id: id}
+		return render(c.out, c.output, created, func(w io.Writer) {
+			row(w, "ID")
+			row(w, id)
+		})
+	}
+}
+
+func updateCommand(fs *flag.FlagSet) func(c *cli, args []string) error {
+	var f userFlags
+	f.register(fs)
+
+	return func(c *cli, args []string) error {
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+
+		patch, ok, err := f.patch(c, fs)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errUsage
+		}
+
+		u, err := c.client.Patch(context.Background(), id, patch)
+		if err != nil {
+			return err
+		}
+		return render(c.out, c.output, u, usersTable([]user.User{u}))
+	}
+}
+
+func deleteCommand(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+
+		if err := c.client.Delete(context.Background(), id); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.err, "user %d deleted\n", id)
+		return nil
+	}
+}
+
+func locationCommand(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(c *cli, args []string) error {
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+
+		location, err := c.client.GetLocation(context.Background(), id)
+		if err != nil {
+			return err
+		}
+		return render(c.out, c.output, location, locationTable(location))
+	}
+}
+
+func importCommand(fs *flag.FlagSet) func(c *cli, args []string) error {
+	file := fs.String("file", "", "CSV, NDJSON or JSON array file with the users, - reads stdin; mandatory")
+	format := fs.String("format", "", "format of the file: csv, ndjson or json; by default the one of its extension, ndjson for stdin")
+	columns := fs.String("columns", "", "comma separated column:field pairs of a CSV file, e.g. full_name:name,birth_date:dob")
+	dryRun := fs.Bool("dry-run", false, "validate the users without creating them")
+
+	return func(c *cli, args []string) error {
+		if *file == "" || len(args) > 0 {
+			return errUsage
+		}
+		if *format == "" {
+			*format = formatOf(*file)
+		}
+		if *format != formatCSV && *format != formatNDJSON && *format != formatJSON {
+			return errUsage
+		}
+
+		mapping, err := user.ParseColumns(*columns)
+		if err != nil {
+			return err
+		}
+
+		var in io.Reader = c.in
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		options := client.ImportOptions{Format: *format, Columns: mapping, DryRun: *dryRun}
+		if *format == formatJSON {
+			// the api reads NDJSON, the line of a user is then its position in the array
+			in = arrayToNDJSON(in)
+			options.Format = user.ImportFormatNDJSON
+		}
+
+		report, err := c.client.Import(context.Background(), in, options)
+		if err != nil {
+			return err
+		}
+
+		err = render(c.out, c.output, report, func(w io.Writer) {
+			row(w, "LINE", "ERRORS")
+			for _, rejected := range report.Errors {
+				row(w, rejected.Line, strings.Join(rejected.Errors, "; "))
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		verb := "created"
+		if report.DryRun {
+			verb = "valid"
+		}
+		fmt.Fprintf(c.err, "%d of %d users %s, %d rejected\n", report.Accepted, report.Rows, verb, report.Rejected)
+		if report.ErrorsTruncated {
+			fmt.Fprintf(c.err, "only the first %d rejected users are listed\n", len(report.Errors))
+		}
+		if report.Rejected > 0 {
+			return fmt.Errorf("%d of %d users rejected", report.Rejected, report.Rows)
+		}
+		return nil
+	}
+}
+
+// formatOf tells the format of an import file by its extension.
+func formatOf(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		return formatCSV
+	case ".json":
+		return formatJSON
+	default:
+		return formatNDJSON
+	}
+}
+
+// arrayToNDJSON streams the items of a JSON array as NDJSON, a file that is not an array is
+// passed through as it is.
+func arrayToNDJSON(in io.Reader) io.Reader {
+	r, w := io.Pipe()
+	go func() {
+		buffered := bufio.NewReader(in)
+		if first, err := peekNonSpace(buffered); err != nil || first != '[' {
+			_, err = io.Copy(w, buffered)
+			w.CloseWithError(err)
+			return
+		}
+
+		decoder := json.NewDecoder(buffered)
+		if _, err := decoder.Token(); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		for decoder.More() {
+			var item json.RawMessage
+			if err := decoder.Decode(&item); err != nil {
+				w.CloseWithError(fmt.Errorf("can't read the array: %v", err))
+				return
+			}
+			// compacting keeps an item on a single line
+			var line bytes.Buffer
+			if err := json.Compact(&line, item); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			line.WriteByte('\n')
+			if _, err := line.WriteTo(w); err != nil {
+				return
+			}
+		}
+		w.Close()
+	}()
+	return r
+}
+
+// peekNonSpace returns the first byte that is not a space without consuming anything.
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for n := 1; ; n++ {
+		peeked, err := r.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		if b := peeked[n-1]; b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, nil
+		}
+	}
+}
+
+func exportCommand(fs *flag.FlagSet) func(c *cli, args []string) error {
+	name := fs.String("name", "", "name of the users, mandatory")
+	format := fs.String("format", formatNDJSON, "format of the file: ndjson or json")
+	file := fs.String("file", "", "file the users are written to, stdout by default")
+	size := fs.Int("size", 0, "size of the pages requested")
+
+	return func(c *cli, args []string) error {
+		if *name == "" || len(args) > 0 || (*format != formatNDJSON && *format != formatJSON) {
+			return errUsage
+		}
+
+		out := c.out
+		if *file != "" {
+			f, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		w := bufio.NewWriter(out)
+
+		count := 0
+		it := c.client.Find(context.Background(), client.FindOptions{Name: *name, Size: *size})
+		for it.Next() {
+			data, err := json.Marshal(it.User())
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case *format == formatNDJSON:
+			case count == 0:
+				w.WriteString("[\n  ")
+			default:
+				w.WriteString(",\n  ")
+			}
+			w.Write(data)
+			if *format == formatNDJSON {
+				w.WriteString("\n")
+			}
+			count++
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+
+		if *format == formatJSON {
+			if count == 0 {
+				w.WriteString("[")
+			}
+			w.WriteString("\n]\n")
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.err, "%d users exported\n", count)
+		return nil
+	}
+}
+
+func parseID(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errUsage
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("%s is not a valid id: %w", args[0], errUsage)
+	}
+	return id, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is not a valid date, use 2006-01-02 or an RFC 3339 time", value)
+	}
+	return t, nil
+}
+
+func splitFields(fields string) []string {
+	if fields == "" {
+		return nil
+	}
+	return strings.Split(fields, ",")
+}
+
+func readFile(c *cli, file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(c.in)
+	}
+	return ioutil.ReadFile(file)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/users-api/pkg/client"
+)
+
+const (
+	defaultProfile = "default"
+	defaultTimeout = 30 * time.Second
+)
+
+// options are the flags every command has. The connection ones fall back to the USERSCTL_*
+// environment variables and then to the profile.
+type options struct {
+	config  string
+	profile string
+	url     string
+	token   string
+	apiKey  string
+	tenant  string
+	output  string
+	timeout time.Duration
+	retries int
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.config, "config", "", "config file with the profiles, $USERSCTL_CONFIG or ~/.usersctl.yml by default")
+	fs.StringVar(&o.profile, "profile", "", "profile of the config file to use, $USERSCTL_PROFILE or its current_profile by default")
+	fs.StringVar(&o.url, "url", "", "url of the api, e.g. http://localhost:8080")
+	fs.StringVar(&o.token, "token", "", "bearer token")
+	fs.StringVar(&o.apiKey, "api-key", "", "api key, instead of a token")
+	fs.StringVar(&o.tenant, "tenant", "", "tenant of the requests")
+	fs.StringVar(&o.output, "o", outputTable, "output format: table, json or yaml")
+	fs.DurationVar(&o.timeout, "timeout", defaultTimeout, "timeout of each request")
+	fs.IntVar(&o.retries, "retries", 2, "retries of the requests that fail with a network error, a 429 or a 5xx")
+}
+
+// profile is an api and its credentials, as kept in the config file:
+//
+//	current_profile: dev
+//	profiles:
+//	  dev:
+//	    url: http://localhost:8080
+//	    token: ...
+//	  live:
+//	    url: https://users.example.com
+//	    api_key: ...
+//	    tenant: acme
+type profile struct {
+	URL    string
+	Token  string
+	APIKey string
+	Tenant string
+}
+
+// loadProfile reads the profile the options name, a missing config file is only an error when
+// it was given explicitly.
+func (o *options) loadProfile() (profile, error) {
+	path := firstOf(o.config, os.Getenv("USERSCTL_CONFIG"))
+	explicit := path != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return profile{}, nil
+		}
+		path = filepath.Join(home, ".usersctl.yml")
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		var notFound *os.PathError
+		if !explicit && errors.As(err, &notFound) {
+			return profile{}, nil
+		}
+		return profile{}, fmt.Errorf("can't read the config file %s: %v", path, err)
+	}
+
+	name := firstOf(o.profile, os.Getenv("USERSCTL_PROFILE"), v.GetString("current_profile"), defaultProfile)
+	key := "profiles." + name
+	if !v.IsSet(key) {
+		if name == defaultProfile && o.profile == "" {
+			return profile{}, nil
+		}
+		return profile{}, fmt.Errorf("the profile %s is not in %s", name, path)
+	}
+
+	return profile{
+		URL:    v.GetString(key + ".url"),
+		Token:  v.GetString(key + ".token"),
+		APIKey: v.GetString(key + ".api_key"),
+		Tenant: v.GetString(key + ".tenant"),
+	}, nil
+}
+
+// newClient returns the client of the flags, the environment and the profile, in that order.
+func (o *options) newClient() (*client.Client, error) {
+	p, err := o.loadProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	url := firstOf(o.url, os.Getenv("USERSCTL_URL"), p.URL)
+	if url == "" {
+		return nil, errors.New("the url of the api is missing, set it with --url, $USERSCTL_URL or a profile")
+	}
+
+	token := firstOf(o.token, os.Getenv("USERSCTL_TOKEN"))
+	apiKey := firstOf(o.apiKey, os.Getenv("USERSCTL_API_KEY"))
+	if token == "" && apiKey == "" {
+		token, apiKey = p.Token, p.APIKey
+	}
+
+	return client.New(client.Config{
+		BaseURL:    url,
+		Token:      token,
+		APIKey:     apiKey,
+		Tenant:     firstOf(o.tenant, os.Getenv("USERSCTL_TENANT"), p.Tenant),
+		Retries:    o.retries,
+		HTTPClient: &http.Client{Timeout: o.timeout},
+		UserAgent:  "usersctl",
+	})
+}
+
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
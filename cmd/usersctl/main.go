@@ -0,0 +1,138 @@
+// usersctl calls a running users-api from the command line, run usersctl help for its commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/users-api/pkg/client"
+)
+
+// command is a subcommand, setup registers its flags and returns the function that runs it
+// with the positional arguments.
+type command struct {
+	usage   string
+	summary string
+	setup   func(fs *flag.FlagSet) func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"get":      {usage: "get [flags] <id>", summary: "Show a user", setup: getCommand},
+	"list":     {usage: "list [flags] --name <name>", summary: "List the users with a name", setup: listCommand},
+	"create":   {usage: "create [flags] (--name <name> | --file <file>)", summary: "Create a user", setup: createCommand},
+	"update":   {usage: "update [flags] <id>", summary: "Change the fields given of a user", setup: updateCommand},
+	"delete":   {usage: "delete [flags] <id>", summary: "Delete a user", setup: deleteCommand},
+	"location": {usage: "location [flags] <id>", summary: "Show the location of the address of a user", setup: locationCommand},
+	"import":   {usage: "import [flags] --file <file>", summary: "Create the users of a CSV, NDJSON or JSON array file", setup: importCommand},
+	"export":   {usage: "export [flags] --name <name>", summary: "Write the users with a name as NDJSON or a JSON array", setup: exportCommand},
+}
+
+// errUsage is returned for invalid arguments, the usage of the command is printed after it.
+var errUsage = errors.New("invalid arguments")
+
+// cli is what the commands run with.
+type cli struct {
+	client *client.Client
+	output string
+	in     io.Reader
+	out    io.Writer
+	err    io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command of the args and returns the exit code: 1 when it failed, 2 when the
+// arguments are invalid.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stdout)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "usersctl: unknown command %s\n\n", name)
+		usage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("usersctl "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: usersctl %s\n\n%s.\n\nFlags:\n", cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	var opts options
+	opts.register(fs)
+	runCommand := cmd.setup(fs)
+
+	positional, err := parse(fs, args[1:])
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if err := checkOutput(opts.output); err != nil {
+		fmt.Fprintf(stderr, "usersctl: %v\n", err)
+		return 2
+	}
+
+	c := &cli{output: opts.output, in: stdin, out: stdout, err: stderr}
+	if c.client, err = opts.newClient(); err != nil {
+		fmt.Fprintf(stderr, "usersctl: %v\n", err)
+		return 1
+	}
+
+	if err := runCommand(c, positional); err != nil {
+		fmt.Fprintf(stderr, "usersctl %s: %v\n", name, err)
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// parse parses the flags wherever they are, before or after the positional arguments, and
+// returns the latter.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usersctl calls a running users-api.")
+	fmt.Fprintln(w, "\nUsage: usersctl <command> [flags]\n\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w, "\nRun usersctl <command> -h for the flags of a command.")
+}
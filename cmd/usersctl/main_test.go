@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/users-api/cmd/server"
+	"github.com/users-api/pkg/user"
+)
+
+// serviceAuthenticator accepts every request as a service with every users scope and any tenant.
+type serviceAuthenticator struct{}
+
+func (serviceAuthenticator) Scheme() string {
+	return "Bearer"
+}
+
+func (serviceAuthenticator) Authenticate(r *http.Request) (server.Claims, error) {
+	scopes := []string{user.ScopeRead, user.ScopeWrite, user.ScopeDelete, user.ScopeLocation, server.ScopeAnyTenant}
+	return server.Claims{"sub": "usersctl-test", "scope": strings.Join(scopes, " ")}, nil
+}
+
+// newTestAPI serves the user routes with the real handlers and service on the mock.
+func newTestAPI(t *testing.T, repository *user.RepositoryMock) string {
+	s := server.New(&server.Config{})
+	s.Use(s.Authentication(serviceAuthenticator{}))
+	s.Use(s.Tenancy(server.TenancyConfig{DefaultTenant: "default"}))
+	user.RegisterRoutes(s, user.NewServiceWithRepository(repository))
+
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func runCommand(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	repository := &user.RepositoryMock{}
+	repository.On("Get", 1).Return(user.User{Id: 1, Name: "Jhon", Address: "Main St"}, nil)
+	repository.On("Get", 2).Return(nil, &user.NotFoundError{Message: errors.New("user 2 not found")})
+	repository.On("GetSurvivor", 2).Return(0, nil)
+	repository.On("Find", mock.Anything).Return(user.UserList{Data: []user.User{{Id: 1, Name: "Jhon"}, {Id: 3, Name: "Jhon"}}, Size: 20}, nil)
+	repository.On("WithTx", mock.Anything).Return(nil)
+	repository.On("Insert", mock.Anything).Return(int64(7), nil)
+	repository.On("InsertMany", mock.Anything).Return([]int64{7}, nil)
+	repository.On("InsertAudit", mock.Anything).Return(nil)
+	repository.On("GetForUpdate", 1).Return(user.User{Id: 1, Name: "Jhon"}, nil)
+	repository.On("Update", 1, mock.Anything).Return(nil)
+	repository.On("Delete", 1).Return(nil)
+
+	url := newTestAPI(t, repository)
+	// an empty config, so that the profiles of the one in the home are not used
+	config := filepath.Join(t.TempDir(), "usersctl.yml")
+	assert.Nil(t, ioutil.WriteFile(config, nil, 0600))
+	csv := filepath.Join(t.TempDir(), "partner.csv")
+	assert.Nil(t, ioutil.WriteFile(csv, []byte("full_name,city\nJhon,Paris\n"), 0600))
+
+	tests := []struct {
+		name   string
+		stdin  string
+		args   []string
+		code   int
+		stdout []string
+		stderr []string
+	}{
+		{
+			name:   "get table",
+			args:   []string{"get", "1"},
+			stdout: []string{"ID  NAME  ADDRESS", "1   Jhon  Main St"},
+		},
+		{
+			name:   "get json with the flags after the id",
+			args:   []string{"get", "1", "-o", "json"},
+			stdout: []string{`"name": "Jhon"`},
+		},
+		{
+			name:   "get yaml",
+			args:   []string{"get", "-o", "yaml", "1"},
+			stdout: []string{"name: Jhon"},
+		},
+		{
+			name:   "get not found",
+			args:   []string{"get", "2"},
+			code:   1,
+			stderr: []string{"404", "NOT_FOUND", "user 2 not found"},
+		},
+		{
+			name:   "get invalid id",
+			args:   []string{"get", "abc"},
+			code:   2,
+			stderr: []string{"abc is not a valid id", "Usage: usersctl get"},
+		},
+		{
+			name:   "list",
+			args:   []string{"list", "--name", "Jhon", "-o", "json"},
+			stdout: []string{`"id": 1`, `"id": 3`},
+		},
+		{
+			name: "list without name",
+			args: []string{"list"},
+			code: 2,
+		},
+		{
+			name:   "create from flags",
+			args:   []string{"create", "--name", "Jhon", "--dob", "1990-05-01"},
+			stdout: []string{"ID", "7"},
+		},
+		{
+			name:   "create from stdin",
+			stdin:  `{"name":"Jhon","address":"Main St"}`,
+			args:   []string{"create", "--file", "-", "-o", "json"},
+			stdout: []string{`"id": 7`},
+		},
+		{
+			name:   "create invalid",
+			args:   []string{"create", "--name", "Jo"},
+			code:   1,
+			stderr: []string{"INVALID_PARAMS"},
+		},
+		{
+			name:   "update",
+			args:   []string{"update", "1", "--address", "Second St"},
+			stdout: []string{"Second St"},
+		},
+		{
+			name:   "delete",
+			args:   []string{"delete", "1"},
+			stderr: []string{"user 1 deleted"},
+		},
+		{
+			name:   "import",
+			stdin:  "{\"name\":\"Jhon\"}\n\n{\"name\":\"Jo\"}\n",
+			args:   []string{"import", "--file", "-"},
+			code:   1,
+			stdout: []string{"LINE  ERRORS", "3     Key: 'User.Name' Error:Field validation for 'Name' failed on the 'min' tag"},
+			stderr: []string{"1 of 2 users created, 1 rejected"},
+		},
+		{
+			name:   "import a JSON array",
+			stdin:  `[{"name": "Jo"}, {"name": "Jhon"}]`,
+			args:   []string{"import", "--file", "-", "--format", "json", "-o", "json"},
+			code:   1,
+			stdout: []string{`"line": 1`, `"accepted": 1`},
+		},
+		{
+			name:   "import a CSV file with a header mapping in a dry run",
+			args:   []string{"import", "--file", csv, "--columns", "full_name:name", "--dry-run"},
+			stderr: []string{"1 of 1 users valid, 0 rejected"},
+		},
+		{
+			name:   "import with an invalid header mapping",
+			args:   []string{"import", "--file", csv, "--columns", "city:address"},
+			code:   1,
+			stderr: []string{"400 Bad Request INVALID_PARAMS", "the header has no name column"},
+		},
+		{
+			name:   "export",
+			args:   []string{"export", "--name", "Jhon"},
+			stdout: []string{"{\"id\":1,", "\n{\"id\":3,"},
+			stderr: []string{"2 users exported"},
+		},
+		{
+			name:   "unknown command",
+			args:   []string{"whoami"},
+			code:   2,
+			stderr: []string{"unknown command whoami", "Commands:"},
+		},
+		{
+			name:   "invalid output",
+			args:   []string{"get", "1", "-o", "xml"},
+			code:   2,
+			stderr: []string{"xml is not a valid output"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCommand(tt.stdin, append(tt.args, "--url", url, "--config", config)...)
+
+			assert.Equal(t, code, tt.code, stderr)
+			for _, s := range tt.stdout {
+				assert.Contains(t, stdout, s)
+			}
+			for _, s := range tt.stderr {
+				assert.Contains(t, stderr, s)
+			}
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	repository := &user.RepositoryMock{}
+	repository.On("Get", 1).Return(user.User{Id: 1, Name: "Jhon"}, nil)
+	url := newTestAPI(t, repository)
+
+	config := filepath.Join(t.TempDir(), "usersctl.yml")
+	err := ioutil.WriteFile(config, []byte(`
+current_profile: dev
+profiles:
+  dev:
+    url: `+url+`
+    tenant: acme
+  live:
+    url: http://localhost:1
+`), 0600)
+	assert.Nil(t, err)
+
+	code, stdout, stderr := runCommand("", "get", "1", "--config", config)
+	assert.Equal(t, code, 0, stderr)
+	assert.Contains(t, stdout, "Jhon")
+	assert.Equal(t, repository.Tenant, "acme")
+
+	code, _, stderr = runCommand("", "get", "1", "--config", config, "--profile", "staging")
+	assert.Equal(t, code, 1)
+	assert.Contains(t, stderr, "the profile staging is not in")
+
+	os.Setenv("USERSCTL_PROFILE", "live")
+	defer os.Unsetenv("USERSCTL_PROFILE")
+	code, stdout, _ = runCommand("", "get", "1", "--config", config, "--url", url, "--tenant", "other")
+	assert.Equal(t, code, 0)
+	assert.Equal(t, repository.Tenant, "other")
+
+	code, _, stderr = runCommand("", "get", "1", "--config", filepath.Join(t.TempDir(), "missing.yml"))
+	assert.Equal(t, code, 1)
+	assert.Contains(t, stderr, "can't read the config file")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/users-api/pkg/user"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+func checkOutput(output string) error {
+	switch output {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("%s is not a valid output, use table, json or yaml", output)
+}
+
+// render writes v in the output format, table writes the rows of the table format.
+func render(w io.Writer, output string, v interface{}, table func(w io.Writer)) error {
+	switch output {
+	case outputJSON:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case outputYAML:
+		// through json so that the fields have the names of the api
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var value interface{}
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		data, err = yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	default:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	}
+}
+
+func row(w io.Writer, columns ...interface{}) {
+	values := make([]string, 0, len(columns))
+	for _, column := range columns {
+		values = append(values, fmt.Sprint(column))
+	}
+	fmt.Fprintln(w, strings.Join(values, "\t"))
+}
+
+func usersTable(users []user.User) func(w io.Writer) {
+	return func(w io.Writer) {
+		row(w, "ID", "NAME", "ADDRESS", "DOB", "CREATED_AT", "UPDATED_AT")
+		for _, u := range users {
+			row(w, u.Id, u.Name, u.Address, formatTime(u.Dob, "2006-01-02"), formatTime(u.CreatedAt, time.RFC3339), formatTime(u.UpdatedAt, time.RFC3339))
+		}
+	}
+}
+
+func locationTable(location user.Location) func(w io.Writer) {
+	return func(w io.Writer) {
+		row(w, "PLACE", "TYPE", "LONGITUDE", "LATITUDE")
+		for _, feature := range location.Features {
+			longitude, latitude := "", ""
+			if len(feature.Center) == 2 {
+				longitude, latitude = fmt.Sprint(feature.Center[0]), fmt.Sprint(feature.Center[1])
+			}
+			row(w, feature.PlaceName, strings.Join(feature.PlaceType, ","), longitude, latitude)
+		}
+	}
+}
+
+func formatTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/users-api/cmd/server"
+)
+
+const (
+	redacted        = "<redacted>"
+	minSecretLength = 32
+)
+
+// secretKeys are the words of the keys whose values redact hides.
+var secretKeys = []string{"secret", "pass", "password", "token", "api_key", "private_key"}
+
+// redact returns the settings with the values of the secret keys replaced.
+func redact(settings map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			out[key] = redact(v)
+		default:
+			if value != nil && value != "" && isSecret(key) {
+				value = redacted
+			}
+			out[key] = value
+		}
+	}
+	return out
+}
+
+func isSecret(key string) bool {
+	for _, word := range strings.Split(key, "_") {
+		for _, secret := range secretKeys {
+			if word == secret {
+				return true
+			}
+		}
+	}
+	for _, secret := range secretKeys {
+		if strings.Contains(secret, "_") && strings.HasSuffix(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// durationSuffixes are the endings of the keys that hold a duration, e.g. 30s.
+var durationSuffixes = []string{"_timeout", ".timeout", "_interval", ".ttl", ".leeway", ".max_age"}
+
+// validateConfig returns the problems of the config, checking it as serve would use it.
+func validateConfig() []string {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port := viper.GetInt("server.port"); port < 1 || port > 65535 {
+		problem("server.port: %d is not a valid port", port)
+	}
+
+	for _, key := range viper.AllKeys() {
+		value, ok := viper.Get(key).(string)
+		if !ok || value == "" || !hasSuffix(key, durationSuffixes) {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			problem("%s: %s is not a valid duration, e.g. 30s", key, value)
+		}
+	}
+
+	for _, key := range viper.AllKeys() {
+		value, ok := viper.Get(key).(string)
+		if !ok || !strings.HasPrefix(key, "versions.") {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			problem("%s: %s is not an RFC 3339 time", key, value)
+		}
+	}
+
+	for _, key := range []string{"database.host", "database.user", "database.name", "pagination.cursor_secret"} {
+		if viper.GetString(key) == "" {
+			problem("%s: is mandatory", key)
+		}
+	}
+
+	// the secrets sign the tokens and cursors, a short one can be guessed
+	for _, key := range []string{"auth.jwt.secret", "pagination.cursor_secret"} {
+		if secret := viper.GetString(key); secret != "" && len(secret) < minSecretLength {
+			problem("%s: must have at least %d characters", key, minSecretLength)
+		}
+	}
+
+	if store := viper.GetString("idempotency.store"); store != "mysql" && store != "memory" {
+		problem("idempotency.store: %s is not valid, use mysql or memory", store)
+	}
+
+	if _, err := server.NewJWTAuthenticator(jwtConfig()); err != nil {
+		problem("auth.jwt: %v", err)
+	}
+
+	if err := server.New(&server.Config{}).CORS(corsConfig()); err != nil {
+		// the errors of the cors config start with cors:
+		problem("%v", err)
+	}
+
+	cfg := serverConfig().TLS
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			problem("server.tls: %v", err)
+		}
+	}
+	if cfg.ClientCAFile != "" {
+		if _, err := ioutil.ReadFile(cfg.ClientCAFile); err != nil {
+			problem("server.tls.client_ca_file: %v", err)
+		}
+	} else if cfg.ClientCertRequired {
+		problem("server.tls.client_cert_required: needs a client_ca_file")
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+func hasSuffix(key string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build info, set at link time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%FT%TZ)" ./cmd
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+func versionInfo() string {
+	return fmt.Sprintf("users-api %s (commit %s, built %s, %s %s/%s)",
+		version, commit, buildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -1,6 +1,6 @@
 module github.com/users-api
 
-go 1.15
+go 1.16
 
 require (
 	github.com/go-playground/validator/v10 v10.6.0
@@ -12,5 +12,6 @@ require (
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/viper v1.7.1
 	github.com/stretchr/testify v1.6.1
-	golang.org/x/text v0.3.5 // indirect
+	golang.org/x/text v0.3.5
+	gopkg.in/yaml.v2 v2.2.8
 )
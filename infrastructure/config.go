@@ -0,0 +1,20 @@
+package infrastructure
+
+import (
+	"github.com/spf13/viper"
+)
+
+// TenantString returns the tenants.<tenant>.<key> override of the tenant, or the key itself
+// when the tenant does not override it.
+func TenantString(tenant string, key string) string {
+	if tenant != "" && viper.IsSet("tenants."+tenant+"."+key) {
+		return viper.GetString("tenants." + tenant + "." + key)
+	}
+	return viper.GetString(key)
+}
+
+// DefaultTenant is the tenant of the requests without one and of the rows created before
+// the tenancy migration.
+func DefaultTenant() string {
+	return viper.GetString("tenancy.default_tenant")
+}
@@ -8,7 +8,8 @@ import (
 	"github.com/spf13/viper"
 )
 
-func ConnectDatabase() *sqlx.DB {
+// OpenDatabase connects to the database of the database.* config, without migrating it.
+func OpenDatabase() (*sqlx.DB, error) {
 	// Get config with viper
 	dbHost := viper.GetString("database.host")
 	dbUser := viper.GetString("database.user")
@@ -17,21 +18,31 @@ func ConnectDatabase() *sqlx.DB {
 	dataSourceName := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=true", dbUser, dbPass, dbHost, dbName)
 	databaseConnection, err := sqlx.Open("mysql", dataSourceName)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	if err := databaseConnection.Ping(); err != nil {
+		return nil, err
 	}
+	logrus.Info("MySQL is connected")
+
+	return databaseConnection, nil
+}
 
-	err = databaseConnection.Ping()
+// ConnectDatabase connects to the database, resetting it in the test env and migrating it up
+// with database.auto_migrate. It panics when it fails.
+func ConnectDatabase() *sqlx.DB {
+	databaseConnection, err := OpenDatabase()
 	if err != nil {
 		panic(err)
 	}
-	logrus.Info("MySQL is connected")
 
 	if viper.GetString("env") == "test" {
-		if err = migrateUpTest(databaseConnection); err != nil {
+		if err = resetTest(databaseConnection); err != nil {
 			panic(err)
 		}
-	} else if viper.GetString("env") == "dev" {
-		if err = migrateUpDevelop(databaseConnection); err != nil {
+	} else if viper.GetBool("database.auto_migrate") {
+		if _, err = MigrateUp(databaseConnection); err != nil {
 			panic(err)
 		}
 	}
@@ -39,17 +50,41 @@ func ConnectDatabase() *sqlx.DB {
 	return databaseConnection
 }
 
-func migrateUpDevelop(db *sqlx.DB) error {
+// resetTest drops every table and migrates them up again, with the fixtures of
+// database.fixtures when it is set.
+func resetTest(db *sqlx.DB) error {
+	if _, err := db.Exec(`DROP TABLE IF EXISTS user, idempotency_key, user_merge, user_audit, api_key, ` + migrationsTable); err != nil {
+		return err
+	}
+
+	if _, err := MigrateUp(db); err != nil {
+		return err
+	}
+
+	if fixtures := viper.GetString("database.fixtures"); fixtures != "" {
+		if _, err := Seed(db, fixtures); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createUserTable merges the tables the dev and test envs used to create: name is a varchar(256)
+// as in dev, the test one was a varchar(48), and address a varchar(256) as in test. The user
+// validation rejects longer names before they reach the column.
+func createUserTable(db *sqlx.DB) error {
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS user (
 			id int NOT NULL AUTO_INCREMENT,
-			address varchar(48) NOT NULL,
+			tenant_id varchar(64) NOT NULL,
+			address varchar(256) NOT NULL,
 			dob date NOT NULL,
 			name varchar(256) NOT NULL,
 			updated_at timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			created_at timestamp NULL DEFAULT CURRENT_TIMESTAMP,
   			PRIMARY KEY (id),
-  			INDEX(name ASC)
+  			INDEX idx_user_tenant_name (tenant_id ASC, name ASC, created_at ASC, id ASC),
+  			FULLTEXT INDEX ft_user_name_address (name, address)
 		)
 		ENGINE=InnoDB
 		DEFAULT CHARSET=utf8mb4
@@ -59,64 +94,156 @@ func migrateUpDevelop(db *sqlx.DB) error {
 	return err
 }
 
-func migrateUpTest(db *sqlx.DB) error {
+// tenantIndexes replace the indexes of the tables created before the tenancy, which are
+// dropped, with indexes that start with the tenant.
+var tenantIndexes = []struct {
+	table   string
+	drop    string
+	name    string
+	columns string
+}{
+	{table: "user", drop: "name", name: "idx_user_tenant_name", columns: "tenant_id, name, created_at, id"},
+	{table: "user_merge", drop: "survivor_id", name: "idx_user_merge_tenant_survivor", columns: "tenant_id, survivor_id"},
+	{table: "user_audit", drop: "user_id", name: "idx_user_audit_tenant_user", columns: "tenant_id, user_id, created_at"},
+	{table: "api_key", name: "idx_api_key_tenant", columns: "tenant_id"},
+}
 
-	if _, err := db.Exec(`DROP TABLE IF EXISTS user`); err != nil {
-		return err
+// migrateTenancy adds the tenant to the tables created before the tenancy. Their rows
+// belong to tenancy.default_tenant.
+func migrateTenancy(db *sqlx.DB) error {
+	tenant := DefaultTenant()
+	if tenant == "" {
+		tenant = "default"
 	}
 
-	if _, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS user (
-			id int NOT NULL AUTO_INCREMENT,
-			address varchar(256) NOT NULL,
-			dob date NOT NULL,
-			name varchar(48) NOT NULL,
-			updated_at timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-			created_at timestamp NULL DEFAULT CURRENT_TIMESTAMP,
-  			PRIMARY KEY (id),
-  			INDEX(name ASC)
-		)
-		ENGINE=InnoDB
-		DEFAULT CHARSET=utf8mb4
-		COLLATE=utf8mb4_0900_ai_ci;
-		`); err != nil {
-		return err
+	for _, index := range tenantIndexes {
+		var columns int
+		if err := db.Get(&columns, `SELECT COUNT(*) FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'tenant_id'`, index.table); err != nil {
+			return err
+		}
+		if columns > 0 {
+			continue
+		}
+
+		logrus.Infof("adding tenant_id to %s, existing rows belong to tenant %s", index.table, tenant)
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN tenant_id varchar(64) NOT NULL DEFAULT '%s' FIRST",
+			index.table, tenant)); err != nil {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN tenant_id DROP DEFAULT", index.table)); err != nil {
+			return err
+		}
+
+		statement := fmt.Sprintf("ALTER TABLE %s ADD INDEX %s (%s)", index.table, index.name, index.columns)
+		if index.drop != "" {
+			statement += fmt.Sprintf(", DROP INDEX %s", index.drop)
+		}
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
 	}
 
-	bulkInsert(db)
 	return nil
 }
 
-func bulkInsert(db *sqlx.DB) {
-	if _, err := db.Exec("INSERT INTO user (name, address, dob) VALUES (?,?,?)", "Jhon", "5th avenue", "1991-01-10"); err != nil {
-		panic(err)
+// addUserFulltext adds the full text index of the search to a user table created before it.
+func addUserFulltext(db *sqlx.DB) error {
+	var indexes int
+	if err := db.Get(&indexes, `SELECT COUNT(*) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'user' AND INDEX_NAME = 'ft_user_name_address'`); err != nil {
+		return err
 	}
-
-	if _, err := db.Exec("INSERT INTO user (name, address, dob) VALUES (?,?,?)", "Jhon", "5th avenue", "1991-01-10"); err != nil {
-		panic(err)
+	if indexes > 0 {
+		return nil
 	}
 
-	if _, err := db.Exec("INSERT INTO user (name, address, dob) VALUES (?,?,?)", "Jhon", "5th avenue", "1991-01-10"); err != nil {
-		panic(err)
-	}
+	logrus.Info("adding the full text index of the search to user")
+	_, err := db.Exec("ALTER TABLE user ADD FULLTEXT INDEX ft_user_name_address (name, address)")
+	return err
+}
 
-	if _, err := db.Exec("INSERT INTO user (name, address, dob) VALUES (?,?,?)", "Jhon", "5th avenue", "1991-01-10"); err != nil {
-		panic(err)
-	}
+func createIdempotencyTable(db *sqlx.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS idempotency_key (
+			idempotency_key varchar(255) NOT NULL,
+			fingerprint char(64) NOT NULL,
+			status int NOT NULL DEFAULT 0,
+			location varchar(512) NOT NULL DEFAULT '',
+			body mediumblob NULL,
+			expires_at timestamp NOT NULL,
+			PRIMARY KEY (idempotency_key),
+			INDEX(expires_at ASC)
+		)
+		ENGINE=InnoDB
+		DEFAULT CHARSET=utf8mb4
+		COLLATE=utf8mb4_0900_ai_ci;
+		`)
 
-	if _, err := db.Exec("INSERT INTO user (name, address, dob) VALUES (?,?,?)", "Jhon", "5th avenue", "1991-01-10"); err != nil {
-		panic(err)
-	}
+	return err
+}
 
-	if _, err := db.Exec("INSERT INTO user (name, address, dob) VALUES (?,?,?)", "Jhon", "5th avenue", "1991-01-10"); err != nil {
-		panic(err)
-	}
+func createMergeTable(db *sqlx.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_merge (
+			tenant_id varchar(64) NOT NULL,
+			merged_id int NOT NULL,
+			survivor_id int NOT NULL,
+			created_at timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (merged_id),
+			INDEX idx_user_merge_tenant_survivor (tenant_id ASC, survivor_id ASC)
+		)
+		ENGINE=InnoDB
+		DEFAULT CHARSET=utf8mb4
+		COLLATE=utf8mb4_0900_ai_ci;
+		`)
 
-	if _, err := db.Exec("INSERT INTO user (name, address, dob) VALUES (?,?,?)", "Jhon", "5th avenue", "1991-01-10"); err != nil {
-		panic(err)
-	}
+	return err
+}
 
-	if _, err := db.Exec("INSERT INTO user (name, address, dob) VALUES (?,?,?)", "Jhon", "5th avenue", "1991-01-10"); err != nil {
-		panic(err)
-	}
+func createAuditTable(db *sqlx.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_audit (
+			id bigint NOT NULL AUTO_INCREMENT,
+			tenant_id varchar(64) NOT NULL,
+			user_id int NOT NULL,
+			action varchar(16) NOT NULL,
+			actor varchar(255) NOT NULL,
+			request_id varchar(64) NOT NULL DEFAULT '',
+			changes json NOT NULL,
+			created_at timestamp(6) NULL DEFAULT CURRENT_TIMESTAMP(6),
+			PRIMARY KEY (id),
+			INDEX idx_user_audit_tenant_user (tenant_id ASC, user_id ASC, created_at ASC)
+		)
+		ENGINE=InnoDB
+		DEFAULT CHARSET=utf8mb4
+		COLLATE=utf8mb4_0900_ai_ci;
+		`)
+
+	return err
+}
+
+func createApiKeyTable(db *sqlx.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_key (
+			id int NOT NULL AUTO_INCREMENT,
+			tenant_id varchar(64) NOT NULL,
+			name varchar(128) NOT NULL,
+			prefix char(12) NOT NULL,
+			hash char(64) NOT NULL,
+			scopes varchar(1024) NOT NULL DEFAULT '',
+			created_at timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at timestamp NULL,
+			expires_at timestamp NULL,
+			revoked tinyint(1) NOT NULL DEFAULT 0,
+			PRIMARY KEY (id),
+			UNIQUE INDEX(prefix),
+			INDEX idx_api_key_tenant (tenant_id ASC)
+		)
+		ENGINE=InnoDB
+		DEFAULT CHARSET=utf8mb4
+		COLLATE=utf8mb4_0900_ai_ci;
+		`)
+
+	return err
 }
@@ -0,0 +1,218 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	migrationsTable = "schema_migrations"
+	// migrationsLock keeps two instances from migrating at the same time
+	migrationsLock        = "users_api_migrations"
+	migrationsLockTimeout = 60
+)
+
+// Migration is a change of the schema. Down reverts it, nil when there is nothing to revert.
+// The migrations of the tables that existed before the versioned migrations only create them
+// when they don't exist, so a database created by older versions is brought under them as is.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(db *sqlx.DB) error
+	Down    func(db *sqlx.DB) error
+}
+
+// MigrationStatus is a migration and when it was applied, nil when it is pending.
+type MigrationStatus struct {
+	Version   int        `db:"version"`
+	Name      string     `db:"name"`
+	AppliedAt *time.Time `db:"applied_at"`
+}
+
+// Migrations of the schema, oldest first. A change of the schema is a new migration, the
+// applied ones must not change.
+var Migrations = []Migration{
+	{Version: 1, Name: "create_user", Up: createUserTable, Down: dropTable("user")},
+	{Version: 2, Name: "create_idempotency_key", Up: createIdempotencyTable, Down: dropTable("idempotency_key")},
+	{Version: 3, Name: "create_user_merge", Up: createMergeTable, Down: dropTable("user_merge")},
+	{Version: 4, Name: "create_user_audit", Up: createAuditTable, Down: dropTable("user_audit")},
+	{Version: 5, Name: "create_api_key", Up: createApiKeyTable, Down: dropTable("api_key")},
+	// the tenant columns are dropped with their tables
+	{Version: 6, Name: "add_tenant", Up: migrateTenancy},
+	// the index is part of the user table created by migration 1, it is dropped with the table
+	{Version: 7, Name: "add_user_fulltext", Up: addUserFulltext},
+}
+
+func dropTable(table string) func(db *sqlx.DB) error {
+	return func(db *sqlx.DB) error {
+		_, err := db.Exec("DROP TABLE IF EXISTS " + table)
+		return err
+	}
+}
+
+// MigrateUp applies the pending migrations and returns them.
+func MigrateUp(db *sqlx.DB) ([]Migration, error) {
+	var applied []Migration
+
+	err := withMigrationsLock(db, func() error {
+		statuses, err := migrationStatuses(db)
+		if err != nil {
+			return err
+		}
+
+		for i, status := range statuses {
+			if status.AppliedAt != nil {
+				continue
+			}
+
+			migration := Migrations[i]
+			logrus.Infof("applying migration %d %s", migration.Version, migration.Name)
+			if err := migration.Up(db); err != nil {
+				return fmt.Errorf("migration %d %s failed: %v", migration.Version, migration.Name, err)
+			}
+			if _, err := db.Exec("INSERT INTO "+migrationsTable+" (version, name) VALUES (?, ?)", migration.Version, migration.Name); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and returns them.
+func MigrateDown(db *sqlx.DB, steps int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := withMigrationsLock(db, func() error {
+		statuses, err := migrationStatuses(db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0 && len(reverted) < steps; i-- {
+			if statuses[i].AppliedAt == nil {
+				continue
+			}
+
+			migration := Migrations[i]
+			logrus.Infof("reverting migration %d %s", migration.Version, migration.Name)
+			if migration.Down != nil {
+				if err := migration.Down(db); err != nil {
+					return fmt.Errorf("reverting migration %d %s failed: %v", migration.Version, migration.Name, err)
+				}
+			}
+			if _, err := db.Exec("DELETE FROM "+migrationsTable+" WHERE version = ?", migration.Version); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// MigrationStatuses lists every migration, oldest first, with when it was applied.
+func MigrationStatuses(db *sqlx.DB) ([]MigrationStatus, error) {
+	if err := createMigrationsTable(db); err != nil {
+		return nil, err
+	}
+	return migrationStatuses(db)
+}
+
+func migrationStatuses(db *sqlx.DB) ([]MigrationStatus, error) {
+	var applied []MigrationStatus
+	if err := db.Select(&applied, "SELECT version, name, applied_at FROM "+migrationsTable); err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[int]*time.Time, len(applied))
+	for _, status := range applied {
+		appliedAt[status.Version] = status.AppliedAt
+	}
+
+	return statusesOf(Migrations, appliedAt)
+}
+
+// statusesOf matches the migrations with the versions applied, the database must not have
+// versions this binary doesn't know.
+func statusesOf(migrations []Migration, appliedAt map[int]*time.Time) ([]MigrationStatus, error) {
+	known := make(map[int]bool, len(migrations))
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if at, ok := appliedAt[migration.Version]; ok {
+			if at == nil {
+				at = &time.Time{}
+			}
+			status.AppliedAt = at
+		}
+		statuses = append(statuses, status)
+	}
+
+	var unknown []int
+	for version := range appliedAt {
+		if !known[version] {
+			unknown = append(unknown, version)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Ints(unknown)
+		return nil, fmt.Errorf("the database has migrations %v this binary doesn't know, the binary is older than the schema", unknown)
+	}
+
+	return statuses, nil
+}
+
+func createMigrationsTable(db *sqlx.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (
+			version int NOT NULL,
+			name varchar(128) NOT NULL,
+			applied_at timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (version)
+		)
+		ENGINE=InnoDB
+		DEFAULT CHARSET=utf8mb4
+		COLLATE=utf8mb4_0900_ai_ci;
+		`)
+
+	return err
+}
+
+// withMigrationsLock runs fn holding a MySQL named lock, on a connection of its own since the
+// lock belongs to the session that took it.
+func withMigrationsLock(db *sqlx.DB, fn func() error) error {
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked int
+	if err := conn.GetContext(ctx, &locked, "SELECT GET_LOCK(?, ?)", migrationsLock, migrationsLockTimeout); err != nil {
+		return err
+	}
+	if locked != 1 {
+		return fmt.Errorf("timeout waiting for the migrations lock, another instance is migrating")
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationsLock); err != nil {
+			logrus.Errorf("error while releasing the migrations lock: %v", err)
+		}
+	}()
+
+	if err := createMigrationsTable(db); err != nil {
+		return err
+	}
+	return fn()
+}
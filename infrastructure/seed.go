@@ -0,0 +1,88 @@
+package infrastructure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/yaml.v2"
+)
+
+// Fixtures are the rows Seed inserts, read from a YAML or JSON file:
+//
+//	users:
+//	  - name: Jhon
+//	    address: 5th avenue
+//	    dob: 1991-01-10
+//
+// Users without tenant_id belong to tenancy.default_tenant.
+type Fixtures struct {
+	Users []UserFixture `yaml:"users"`
+}
+
+type UserFixture struct {
+	TenantId string `yaml:"tenant_id"`
+	Name     string `yaml:"name"`
+	Address  string `yaml:"address"`
+	Dob      string `yaml:"dob"`
+}
+
+// ReadFixtures reads and checks the fixtures file.
+func ReadFixtures(path string) (Fixtures, error) {
+	var fixtures Fixtures
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fixtures, err
+	}
+	if err := yaml.UnmarshalStrict(data, &fixtures); err != nil {
+		return fixtures, fmt.Errorf("can't read the fixtures of %s: %v", path, err)
+	}
+
+	for i, user := range fixtures.Users {
+		if user.Name == "" {
+			return fixtures, fmt.Errorf("user %d of %s has no name", i+1, path)
+		}
+		if _, err := time.Parse("2006-01-02", user.Dob); err != nil {
+			return fixtures, fmt.Errorf("user %d of %s has an invalid dob, use 2006-01-02", i+1, path)
+		}
+	}
+
+	return fixtures, nil
+}
+
+// Seed inserts the fixtures of the file in a single transaction and returns the number of
+// rows inserted.
+func Seed(db *sqlx.DB, path string) (int, error) {
+	fixtures, err := ReadFixtures(path)
+	if err != nil {
+		return 0, err
+	}
+
+	tenant := DefaultTenant()
+	if tenant == "" {
+		tenant = "default"
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, user := range fixtures.Users {
+		if user.TenantId == "" {
+			user.TenantId = tenant
+		}
+		if _, err := tx.Exec("INSERT INTO user (tenant_id, name, address, dob) VALUES (?,?,?,?)",
+			user.TenantId, user.Name, user.Address, user.Dob); err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(fixtures.Users), nil
+}
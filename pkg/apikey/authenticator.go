@@ -0,0 +1,61 @@
+package apikey
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/users-api/cmd/server"
+)
+
+const (
+	APIKeyHeader = "X-API-Key"
+
+	apiKeyScheme string = "ApiKey"
+)
+
+// Authenticator accepts the api keys sent in the X-API-Key header. The claims of a key have
+// its name as subject, its scopes and its tenant.
+type Authenticator struct {
+	service IService
+}
+
+func NewAuthenticator(service IService) server.Authenticator {
+	return &Authenticator{service: service}
+}
+
+func (a *Authenticator) Scheme() string {
+	return apiKeyScheme
+}
+
+func (a *Authenticator) SecurityScheme() server.SecurityScheme {
+	return server.SecurityScheme{
+		Type:        "apiKey",
+		In:          "header",
+		Name:        APIKeyHeader,
+		Description: "An api key created with POST /v1/api-keys.",
+	}
+}
+
+func (a *Authenticator) Authenticate(r *http.Request) (server.Claims, error) {
+	secret := r.Header.Get(APIKeyHeader)
+	if secret == "" {
+		return nil, server.ErrNoCredentials
+	}
+
+	key, err := a.service.Authenticate(secret)
+	switch err {
+	case nil:
+	case ErrInvalidKey, ErrRevokedKey, ErrExpiredKey:
+		return nil, &server.AuthError{Scheme: apiKeyScheme, Message: err.Error()}
+	default:
+		return nil, err
+	}
+
+	return server.Claims{
+		"sub":        "apikey:" + key.Name,
+		"scope":      strings.Join(key.Scopes, " "),
+		"api_key_id": fmt.Sprint(key.Id),
+		"tenant_id":  key.TenantId,
+	}, nil
+}
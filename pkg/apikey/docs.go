@@ -0,0 +1,41 @@
+package apikey
+
+import (
+	"net/http"
+
+	"github.com/users-api/cmd/server"
+)
+
+// Docs of the routes in the OpenAPI document.
+
+var idParam = server.Param{Name: "id", In: "path", Type: "integer", Description: "Id of the key"}
+
+var createDoc = server.Doc{
+	Summary:     "Create an api key",
+	Description: "The secret is only returned here, store it safely.",
+	Tags:        []string{"api keys"},
+	Request:     CreateRequest{},
+	Responses:   map[int]interface{}{http.StatusCreated: Secret{}},
+}
+
+var listDoc = server.Doc{
+	Summary:   "List the api keys of the tenant",
+	Tags:      []string{"api keys"},
+	Responses: map[int]interface{}{http.StatusOK: APIKeyList{}},
+}
+
+var rotateDoc = server.Doc{
+	Summary:   "Replace the secret of an api key",
+	Tags:      []string{"api keys"},
+	Params:    []server.Param{idParam},
+	Responses: map[int]interface{}{http.StatusOK: Secret{}},
+	Errors:    []int{http.StatusNotFound, http.StatusConflict},
+}
+
+var revokeDoc = server.Doc{
+	Summary:   "Revoke an api key",
+	Tags:      []string{"api keys"},
+	Params:    []server.Param{idParam},
+	Responses: map[int]interface{}{http.StatusOK: nil},
+	Errors:    []int{http.StatusNotFound},
+}
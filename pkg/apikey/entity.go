@@ -0,0 +1,77 @@
+package apikey
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// APIKey is a key a client sends in the X-API-Key header. Only the hash of the secret is
+// stored, Prefix identifies the key without revealing it.
+type APIKey struct {
+	Id         int        `db:"id" json:"id"`
+	TenantId   string     `db:"tenant_id" json:"tenant_id"`
+	Name       string     `db:"name" json:"name"`
+	Prefix     string     `db:"prefix" json:"prefix"`
+	Hash       string     `db:"hash" json:"-"`
+	Scopes     Scopes     `db:"scopes" json:"scopes"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	Revoked    bool       `db:"revoked" json:"revoked"`
+}
+
+// Expired checks the expiry of the key at the given time, keys without expiry never expire.
+func (k *APIKey) Expired(at time.Time) bool {
+	return k.ExpiresAt != nil && !at.Before(*k.ExpiresAt)
+}
+
+// Secret is a key together with its secret, which is only returned when created or rotated.
+type Secret struct {
+	APIKey
+	Secret string `json:"secret"`
+}
+
+type APIKeyList struct {
+	Data []APIKey `json:"data"`
+}
+
+type CreateRequest struct {
+	Name      string     `json:"name" validate:"required,max=128"`
+	Scopes    Scopes     `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (c *CreateRequest) Validate() error {
+	if err := validator.New().Struct(c); err != nil {
+		return err
+	}
+	if c.ExpiresAt != nil && !c.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
+
+// Scopes are stored as a space separated list.
+type Scopes []string
+
+func (s Scopes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+func (s *Scopes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		*s = strings.Fields(string(v))
+	case string:
+		*s = strings.Fields(v)
+	case nil:
+		*s = Scopes{}
+	default:
+		return fmt.Errorf("unsupported scopes type %T", value)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package apikey
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/users-api/cmd/server"
+)
+
+const (
+	// ScopeAdmin grants the management of the api keys.
+	ScopeAdmin string = "apikeys:admin"
+
+	ErrorCodeInvalidParams string = "INVALID_PARAMS"
+	ErrorCodeKeyRevoked    string = "API_KEY_REVOKED"
+)
+
+type IHandler interface {
+	Create(w http.ResponseWriter, r *http.Request)
+	List(w http.ResponseWriter, r *http.Request)
+	Rotate(w http.ResponseWriter, r *http.Request)
+	Revoke(w http.ResponseWriter, r *http.Request)
+}
+
+type Handler struct {
+	service IService
+}
+
+// Create returns the secret of the new key, it is the only time it is shown.
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	var request CreateRequest
+
+	if err := server.DecodeJSON(r, &request); err != nil {
+		server.BodyError(w, r, ErrorCodeInvalidParams, err)
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		server.BadRequest(w, r, ErrorCodeInvalidParams, err.Error())
+		return
+	}
+
+	key, err := h.service.Create(r.Context(), request)
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	w.Header().Add("location", fmt.Sprintf("api-keys/%d", key.Id))
+	server.Render(w, r, key, http.StatusCreated)
+}
+
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.service.List(r.Context())
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	server.OK(w, r, keys)
+}
+
+// Rotate returns the new secret of the key.
+func (h *Handler) Rotate(w http.ResponseWriter, r *http.Request) {
+	keyId, err := server.GetIntFromPath(r, "id")
+	if err != nil {
+		server.BadRequest(w, r, "")
+		return
+	}
+
+	key, err := h.service.Rotate(r.Context(), keyId)
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	server.OK(w, r, key)
+}
+
+func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+	keyId, err := server.GetIntFromPath(r, "id")
+	if err != nil {
+		server.BadRequest(w, r, "")
+		return
+	}
+
+	if err := h.service.Revoke(r.Context(), keyId); err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	server.OK(w, r, nil)
+}
+
+func handlerException(w http.ResponseWriter, r *http.Request, err error) {
+	switch err.(type) {
+	case *NotFoundError:
+		server.NotFound(w, r, err.Error())
+	case *RevokedError:
+		server.Conflict(w, r, ErrorCodeKeyRevoked, err.Error())
+	case *ScopeError:
+		server.Forbidden(w, r, server.ErrorCodeInsufficientScope, err.Error())
+	default:
+		logrus.Error(err)
+		server.InternalServerError(w, r, err)
+	}
+}
+
+func RegisterRoutes(s *server.Server, service IService) {
+
+	handler := newHandler(service)
+	v1 := s.APIVersion("1")
+
+	v1.AddRoute("/api-keys", handler.Create, http.MethodPost).Name("apikeys.create").Scopes(ScopeAdmin).Doc(createDoc)
+	v1.AddRoute("/api-keys", handler.List, http.MethodGet).Name("apikeys.list").Scopes(ScopeAdmin).Doc(listDoc)
+	v1.AddRoute("/api-keys/{id}/rotate", handler.Rotate, http.MethodPost).Name("apikeys.rotate").Scopes(ScopeAdmin).Doc(rotateDoc)
+	v1.AddRoute("/api-keys/{id}/revoke", handler.Revoke, http.MethodPost).Name("apikeys.revoke").Scopes(ScopeAdmin).Doc(revokeDoc)
+}
+
+func newHandler(service IService) IHandler {
+	return &Handler{
+		service: service,
+	}
+}
@@ -0,0 +1,125 @@
+package apikey
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type IRepository interface {
+	Insert(key *APIKey) (int64, error)
+	Get(tenant string, keyId int) (APIKey, error)
+	// GetByPrefix looks the key up in every tenant, the tenant of a caller is only known
+	// once its key is found.
+	GetByPrefix(prefix string) (APIKey, error)
+	List(tenant string) (APIKeyList, error)
+	UpdateSecret(tenant string, keyId int, prefix string, hash string) error
+	Revoke(tenant string, keyId int) error
+	Touch(keyId int) error
+}
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+type NotFoundError struct {
+	Message error
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Message.Error()
+}
+
+const (
+	insertKeySQL      string = "INSERT INTO api_key (tenant_id, name, prefix, hash, scopes, expires_at) VALUES (:tenant_id, :name, :prefix, :hash, :scopes, :expires_at)"
+	getKeySQL         string = "SELECT id, tenant_id, name, prefix, hash, scopes, created_at, last_used_at, expires_at, revoked FROM api_key WHERE tenant_id = ? AND id = ?"
+	getKeyByPrefixSQL string = "SELECT id, tenant_id, name, prefix, hash, scopes, created_at, last_used_at, expires_at, revoked FROM api_key WHERE prefix = ?"
+	listKeysSQL       string = "SELECT id, tenant_id, name, prefix, hash, scopes, created_at, last_used_at, expires_at, revoked FROM api_key WHERE tenant_id = ? ORDER BY id"
+	updateSecretSQL   string = "UPDATE api_key SET prefix = ?, hash = ? WHERE tenant_id = ? AND id = ? AND revoked = false"
+	revokeKeySQL      string = "UPDATE api_key SET revoked = true WHERE tenant_id = ? AND id = ?"
+	// last_used_at is written at most once a minute so that every request is not a write
+	touchKeySQL string = "UPDATE api_key SET last_used_at = CURRENT_TIMESTAMP WHERE id = ? AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL 1 MINUTE)"
+
+	keyNotFound string = "api key with id=%d not found"
+)
+
+func (r *Repository) Insert(key *APIKey) (int64, error) {
+	result, err := r.db.NamedExec(insertKeySQL, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (r *Repository) Get(tenant string, keyId int) (APIKey, error) {
+	key := APIKey{}
+	err := r.db.Get(&key, getKeySQL, tenant, keyId)
+
+	if err == sql.ErrNoRows {
+		return key, &NotFoundError{Message: fmt.Errorf(keyNotFound, keyId)}
+	}
+
+	return key, err
+}
+
+func (r *Repository) GetByPrefix(prefix string) (APIKey, error) {
+	key := APIKey{}
+	err := r.db.Get(&key, getKeyByPrefixSQL, prefix)
+
+	if err == sql.ErrNoRows {
+		return key, &NotFoundError{Message: fmt.Errorf("api key %s not found", prefix)}
+	}
+
+	return key, err
+}
+
+func (r *Repository) List(tenant string) (APIKeyList, error) {
+	keys := make([]APIKey, 0)
+	if err := r.db.Select(&keys, listKeysSQL, tenant); err != nil {
+		return APIKeyList{}, err
+	}
+
+	return APIKeyList{Data: keys}, nil
+}
+
+// UpdateSecret replaces the secret of a key that is not revoked.
+func (r *Repository) UpdateSecret(tenant string, keyId int, prefix string, hash string) error {
+	return r.affectOne(keyId, updateSecretSQL, prefix, hash, tenant, keyId)
+}
+
+func (r *Repository) Revoke(tenant string, keyId int) error {
+	_, err := r.db.Exec(revokeKeySQL, tenant, keyId)
+	return err
+}
+
+func (r *Repository) Touch(keyId int) error {
+	_, err := r.db.Exec(touchKeySQL, keyId)
+	return err
+}
+
+// affectOne runs an update that must change the key, otherwise the key is not found.
+func (r *Repository) affectOne(keyId int, query string, args ...interface{}) error {
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &NotFoundError{Message: fmt.Errorf(keyNotFound, keyId)}
+	}
+
+	return nil
+}
+
+// NewRepository returns a repository on db, the pool the server shares with the other packages.
+func NewRepository(db *sqlx.DB) IRepository {
+	return &Repository{
+		db: db,
+	}
+}
@@ -0,0 +1,44 @@
+package apikey
+
+import (
+	"github.com/stretchr/testify/mock"
+)
+
+type RepositoryMock struct {
+	mock.Mock
+}
+
+func (m *RepositoryMock) Insert(key *APIKey) (int64, error) {
+	args := m.Called(key)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *RepositoryMock) Get(tenant string, keyId int) (APIKey, error) {
+	args := m.Called(tenant, keyId)
+	return args.Get(0).(APIKey), args.Error(1)
+}
+
+func (m *RepositoryMock) GetByPrefix(prefix string) (APIKey, error) {
+	args := m.Called(prefix)
+	return args.Get(0).(APIKey), args.Error(1)
+}
+
+func (m *RepositoryMock) List(tenant string) (APIKeyList, error) {
+	args := m.Called(tenant)
+	return args.Get(0).(APIKeyList), args.Error(1)
+}
+
+func (m *RepositoryMock) UpdateSecret(tenant string, keyId int, prefix string, hash string) error {
+	args := m.Called(tenant, keyId, prefix, hash)
+	return args.Error(0)
+}
+
+func (m *RepositoryMock) Revoke(tenant string, keyId int) error {
+	args := m.Called(tenant, keyId)
+	return args.Error(0)
+}
+
+func (m *RepositoryMock) Touch(keyId int) error {
+	args := m.Called(keyId)
+	return args.Error(0)
+}
@@ -0,0 +1,57 @@
+package apikey
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/users-api/infrastructure"
+)
+
+func setTestEnvironment() {
+	viper.Set("env", "test")
+	viper.Set("database.host", "localhost:3305")
+	viper.Set("database.pass", "root")
+	viper.Set("database.user", "root")
+	viper.Set("database.name", "challenge")
+}
+
+func TestRepository_Lifecycle(t *testing.T) {
+	setTestEnvironment()
+	repository := NewRepository(infrastructure.ConnectDatabase())
+
+	id, err := repository.Insert(&APIKey{TenantId: "acme", Name: "importer", Prefix: "0123456789ab", Hash: hash("secret"), Scopes: Scopes{"users:read", "users:write"}})
+	assert.Nil(t, err)
+
+	key, err := repository.GetByPrefix("0123456789ab")
+	assert.Nil(t, err)
+	assert.Equal(t, key.Id, int(id))
+	assert.Equal(t, key.Scopes, Scopes{"users:read", "users:write"})
+	assert.Nil(t, key.LastUsedAt)
+
+	assert.Nil(t, repository.Touch(key.Id))
+	assert.IsType(t, &NotFoundError{}, repository.UpdateSecret("other", key.Id, "ba9876543210", hash("other")))
+	assert.Nil(t, repository.UpdateSecret("acme", key.Id, "ba9876543210", hash("other")))
+
+	_, err = repository.GetByPrefix("0123456789ab")
+	assert.IsType(t, &NotFoundError{}, err)
+
+	assert.Nil(t, repository.Revoke("acme", key.Id))
+	assert.IsType(t, &NotFoundError{}, repository.UpdateSecret("acme", key.Id, "0123456789ab", hash("secret")))
+
+	_, err = repository.Get("other", key.Id)
+	assert.IsType(t, &NotFoundError{}, err)
+
+	key, err = repository.Get("acme", key.Id)
+	assert.Nil(t, err)
+	assert.True(t, key.Revoked)
+	assert.NotNil(t, key.LastUsedAt)
+
+	list, err := repository.List("acme")
+	assert.Nil(t, err)
+	assert.Len(t, list.Data, 1)
+
+	list, err = repository.List("other")
+	assert.Nil(t, err)
+	assert.Empty(t, list.Data)
+}
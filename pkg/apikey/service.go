@@ -0,0 +1,206 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/users-api/cmd/server"
+)
+
+const (
+	// secrets look like uak_<prefix>_<random>, the prefix is the lookup key of the hash
+	secretPrefix string = "uak_"
+	prefixBytes  int    = 6
+	secretBytes  int    = 32
+)
+
+var (
+	ErrInvalidKey = errors.New("the api key is invalid")
+	ErrRevokedKey = errors.New("the api key is revoked")
+	ErrExpiredKey = errors.New("the api key is expired")
+)
+
+// RevokedError is returned when rotating a revoked key.
+type RevokedError struct {
+	KeyId int
+}
+
+func (e *RevokedError) Error() string {
+	return "revoked api keys can't be rotated"
+}
+
+// ScopeError is returned when creating a key with scopes the caller was not granted.
+type ScopeError struct {
+	Scopes []string
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("the %s scope can't be granted, the caller doesn't have it", strings.Join(e.Scopes, ", "))
+}
+
+type IService interface {
+	Create(ctx context.Context, request CreateRequest) (Secret, error)
+	List(ctx context.Context) (APIKeyList, error)
+	Rotate(ctx context.Context, keyId int) (Secret, error)
+	Revoke(ctx context.Context, keyId int) error
+	Authenticate(secret string) (APIKey, error)
+}
+
+type Service struct {
+	repository IRepository
+	now        func() time.Time
+}
+
+// Create stores a new key of the tenant of the caller, its secret can't be read again afterwards.
+// The key can only be granted scopes the caller has, so that it can't be used to escalate them.
+func (s *Service) Create(ctx context.Context, request CreateRequest) (Secret, error) {
+	if err := checkScopes(ctx, request.Scopes); err != nil {
+		return Secret{}, err
+	}
+
+	prefix, secret, err := newSecret()
+	if err != nil {
+		return Secret{}, err
+	}
+
+	key := APIKey{
+		TenantId:  server.GetTenant(ctx),
+		Name:      request.Name,
+		Prefix:    prefix,
+		Hash:      hash(secret),
+		Scopes:    request.Scopes,
+		CreatedAt: s.now(),
+		ExpiresAt: request.ExpiresAt,
+	}
+
+	id, err := s.repository.Insert(&key)
+	if err != nil {
+		return Secret{}, err
+	}
+	key.Id = int(id)
+
+	return Secret{APIKey: key, Secret: secret}, nil
+}
+
+// checkScopes returns a ScopeError listing the scopes the caller was not granted.
+func checkScopes(ctx context.Context, scopes []string) error {
+	claims := server.GetClaims(ctx)
+	missing := make([]string, 0)
+	for _, scope := range scopes {
+		if !claims.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return &ScopeError{Scopes: missing}
+	}
+	return nil
+}
+
+func (s *Service) List(ctx context.Context) (APIKeyList, error) {
+	return s.repository.List(server.GetTenant(ctx))
+}
+
+// Rotate replaces the secret of the key, the previous one stops working at once. Like Create,
+// only a caller with every scope of the key gets its new secret.
+func (s *Service) Rotate(ctx context.Context, keyId int) (Secret, error) {
+	tenant := server.GetTenant(ctx)
+
+	key, err := s.repository.Get(tenant, keyId)
+	if err != nil {
+		return Secret{}, err
+	}
+	if key.Revoked {
+		return Secret{}, &RevokedError{KeyId: keyId}
+	}
+	if err := checkScopes(ctx, key.Scopes); err != nil {
+		return Secret{}, err
+	}
+
+	prefix, secret, err := newSecret()
+	if err != nil {
+		return Secret{}, err
+	}
+
+	if err := s.repository.UpdateSecret(tenant, keyId, prefix, hash(secret)); err != nil {
+		return Secret{}, err
+	}
+	key.Prefix, key.Hash = prefix, hash(secret)
+
+	return Secret{APIKey: key, Secret: secret}, nil
+}
+
+func (s *Service) Revoke(ctx context.Context, keyId int) error {
+	tenant := server.GetTenant(ctx)
+
+	if _, err := s.repository.Get(tenant, keyId); err != nil {
+		return err
+	}
+
+	return s.repository.Revoke(tenant, keyId)
+}
+
+// Authenticate returns the key of the secret when it is valid, not revoked and not expired.
+func (s *Service) Authenticate(secret string) (APIKey, error) {
+	parts := strings.SplitN(strings.TrimPrefix(secret, secretPrefix), "_", 2)
+	if !strings.HasPrefix(secret, secretPrefix) || len(parts) != 2 {
+		return APIKey{}, ErrInvalidKey
+	}
+
+	key, err := s.repository.GetByPrefix(parts[0])
+	if _, ok := err.(*NotFoundError); ok {
+		return APIKey{}, ErrInvalidKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash(secret))) != 1 {
+		return APIKey{}, ErrInvalidKey
+	}
+	if key.Revoked {
+		return APIKey{}, ErrRevokedKey
+	}
+	if key.Expired(s.now()) {
+		return APIKey{}, ErrExpiredKey
+	}
+
+	if err := s.repository.Touch(key.Id); err != nil {
+		logrus.Errorf("error while updating last use of api key %d: %v", key.Id, err)
+	}
+
+	return key, nil
+}
+
+func newSecret() (string, string, error) {
+	b := make([]byte, prefixBytes+secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	prefix := hex.EncodeToString(b[:prefixBytes])
+	return prefix, secretPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(b[prefixBytes:]), nil
+}
+
+// hash is a plain SHA-256, the secrets are random so they don't need a slow password hash.
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func NewService(db *sqlx.DB) IService {
+	return &Service{
+		repository: NewRepository(db),
+		now:        time.Now,
+	}
+}
@@ -0,0 +1,175 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/users-api/cmd/server"
+)
+
+func TestService_Create(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+	repositoryMock.On("Insert", mock.MatchedBy(func(key *APIKey) bool {
+		return key.Name == "importer" && key.TenantId == "acme" && len(key.Hash) == 64
+	})).Return(int64(3), nil).Once()
+
+	service := Service{repository: repositoryMock, now: time.Now}
+
+	ctx := server.WithClaims(server.WithTenant(context.Background(), "acme"), server.Claims{"scope": "apikeys:admin users:read users:write"})
+	key, err := service.Create(ctx, CreateRequest{Name: "importer", Scopes: Scopes{"users:write"}})
+
+	repositoryMock.AssertExpectations(t)
+	assert.Nil(t, err)
+	assert.Equal(t, key.Id, 3)
+	assert.True(t, strings.HasPrefix(key.Secret, secretPrefix+key.Prefix+"_"))
+	assert.Equal(t, key.Hash, hash(key.Secret))
+}
+
+func TestService_CreateScopes(t *testing.T) {
+
+	// no Insert expected: the key must not be stored
+	repositoryMock := &RepositoryMock{}
+	service := Service{repository: repositoryMock, now: time.Now}
+
+	ctx := server.WithClaims(server.WithTenant(context.Background(), "acme"), server.Claims{"scope": "apikeys:admin users:read"})
+	_, err := service.Create(ctx, CreateRequest{Name: "importer", Scopes: Scopes{"users:read", "users:write", "tenants:any"}})
+
+	repositoryMock.AssertExpectations(t)
+	assert.Equal(t, err, &ScopeError{Scopes: []string{"users:write", "tenants:any"}})
+	assert.EqualError(t, err, "the users:write, tenants:any scope can't be granted, the caller doesn't have it")
+}
+
+func TestService_Authenticate(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	prefix, secret, err := newSecret()
+	assert.Nil(t, err)
+	key := APIKey{Id: 1, Name: "importer", Prefix: prefix, Hash: hash(secret), Scopes: Scopes{"users:read"}}
+
+	tests := []struct {
+		name        string
+		initMocks   func()
+		secret      string
+		assertError func(*testing.T, error)
+	}{
+		{
+			name: "Success - valid key",
+			initMocks: func() {
+				repositoryMock.On("GetByPrefix", prefix).Return(key, nil).Once()
+				repositoryMock.On("Touch", 1).Return(nil).Once()
+			},
+			secret: secret,
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+		},
+		{
+			name:      "Error - malformed key",
+			initMocks: func() {},
+			secret:    "secret",
+			assertError: func(t *testing.T, e error) {
+				assert.Equal(t, e, ErrInvalidKey)
+			},
+		},
+		{
+			name: "Error - wrong secret",
+			initMocks: func() {
+				repositoryMock.On("GetByPrefix", prefix).Return(key, nil).Once()
+			},
+			secret: secret + "x",
+			assertError: func(t *testing.T, e error) {
+				assert.Equal(t, e, ErrInvalidKey)
+			},
+		},
+		{
+			name: "Error - unknown prefix",
+			initMocks: func() {
+				repositoryMock.On("GetByPrefix", "000000000000").Return(APIKey{}, &NotFoundError{Message: errors.New("not found")}).Once()
+			},
+			secret: secretPrefix + "000000000000_secret",
+			assertError: func(t *testing.T, e error) {
+				assert.Equal(t, e, ErrInvalidKey)
+			},
+		},
+		{
+			name: "Error - revoked key",
+			initMocks: func() {
+				revoked := key
+				revoked.Revoked = true
+				repositoryMock.On("GetByPrefix", prefix).Return(revoked, nil).Once()
+			},
+			secret: secret,
+			assertError: func(t *testing.T, e error) {
+				assert.Equal(t, e, ErrRevokedKey)
+			},
+		},
+		{
+			name: "Error - expired key",
+			initMocks: func() {
+				expired := key
+				expired.ExpiresAt = &past
+				repositoryMock.On("GetByPrefix", prefix).Return(expired, nil).Once()
+			},
+			secret: secret,
+			assertError: func(t *testing.T, e error) {
+				assert.Equal(t, e, ErrExpiredKey)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.initMocks()
+			service := Service{repository: repositoryMock, now: func() time.Time { return now }}
+
+			_, err := service.Authenticate(tt.secret)
+			repositoryMock.AssertExpectations(t)
+			tt.assertError(t, err)
+		})
+	}
+}
+
+func TestService_Rotate(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+	repositoryMock.On("Get", "acme", 1).Return(APIKey{Id: 1, Prefix: "aaaaaaaaaaaa", Scopes: Scopes{"users:write"}}, nil).Once()
+	repositoryMock.On("UpdateSecret", "acme", 1, mock.Anything, mock.Anything).Return(nil).Once()
+	repositoryMock.On("Get", "acme", 2).Return(APIKey{Id: 2, Revoked: true}, nil).Once()
+
+	service := Service{repository: repositoryMock, now: time.Now}
+
+	ctx := server.WithClaims(server.WithTenant(context.Background(), "acme"), server.Claims{"scope": "apikeys:admin users:write"})
+
+	key, err := service.Rotate(ctx, 1)
+	assert.Nil(t, err)
+	assert.NotEqual(t, key.Prefix, "aaaaaaaaaaaa")
+	assert.Equal(t, key.Hash, hash(key.Secret))
+
+	_, err = service.Rotate(ctx, 2)
+	assert.IsType(t, &RevokedError{}, err)
+
+	repositoryMock.AssertExpectations(t)
+}
+
+func TestService_RotateScopes(t *testing.T) {
+
+	// no UpdateSecret expected: the secret must not change
+	repositoryMock := &RepositoryMock{}
+	repositoryMock.On("Get", "acme", 1).Return(APIKey{Id: 1, Scopes: Scopes{"users:read", "tenants:any"}}, nil).Once()
+
+	service := Service{repository: repositoryMock, now: time.Now}
+
+	ctx := server.WithClaims(server.WithTenant(context.Background(), "acme"), server.Claims{"scope": "apikeys:admin users:read"})
+	_, err := service.Rotate(ctx, 1)
+
+	repositoryMock.AssertExpectations(t)
+	assert.Equal(t, err, &ScopeError{Scopes: []string{"tenants:any"}})
+}
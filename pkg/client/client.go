@@ -0,0 +1,243 @@
+// Package client is the Go client of users-api, it sends the requests with the auth, tenant and
+// retries of its Config and turns the error responses into *Error values.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/users-api/cmd/server"
+	"github.com/users-api/pkg/apikey"
+)
+
+const (
+	defaultVersion      = "1"
+	defaultTimeout      = 30 * time.Second
+	defaultRetryBackoff = 200 * time.Millisecond
+	maxRetryBackoff     = 10 * time.Second
+)
+
+type Config struct {
+	// BaseURL is the url the api is served at, e.g. https://users.example.com
+	BaseURL string
+	// Version of the api the requests are sent to, 1 when empty
+	Version string
+	// Token is sent as a bearer token, APIKey in the X-API-Key header; at most one of them is set
+	Token  string
+	APIKey string
+	// Tenant is sent in the X-Tenant-ID header when set
+	Tenant string
+	// Retries of a request that failed with a network error, a 429 or a 502, 503 or 504
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled on each one, 200ms when zero.
+	// A Retry-After header takes precedence.
+	RetryBackoff time.Duration
+	// HTTPClient sends the requests, a client with a 30s timeout when nil
+	HTTPClient *http.Client
+	UserAgent  string
+}
+
+type Client struct {
+	cfg     Config
+	baseURL *url.URL
+	http    *http.Client
+}
+
+func New(c Config) (*Client, error) {
+	if c.BaseURL == "" {
+		return nil, errors.New("the base url is mandatory")
+	}
+	if c.Token != "" && c.APIKey != "" {
+		return nil, errors.New("use either a token or an api key")
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(c.BaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url %s: %v", c.BaseURL, err)
+	}
+
+	if c.Version == "" {
+		c.Version = defaultVersion
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = defaultRetryBackoff
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	return &Client{cfg: c, baseURL: baseURL, http: httpClient}, nil
+}
+
+// call is a request to the api, path is relative to the version, e.g. /users/1.
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	// stream is sent as the body instead, with its contentType; it can only be read once so the
+	// request is never retried
+	stream      io.Reader
+	contentType string
+	// idempotent requests are sent with an Idempotency-Key so that their retries are safe
+	idempotent bool
+}
+
+// do sends the request, retrying it when it failed with a retryable error, and decodes the
+// body of a successful response into out when not nil.
+func (c *Client) do(ctx context.Context, req call, out interface{}) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, fmt.Errorf("can't marshal the body: %v", err)
+		}
+	}
+
+	u := *c.baseURL
+	u.Path += "/v" + c.cfg.Version + req.path
+	u.RawQuery = req.query.Encode()
+
+	header := c.header()
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+	}
+	retries := c.cfg.Retries
+	if req.stream != nil {
+		header.Set("Content-Type", req.contentType)
+		retries = 0
+	}
+	if req.idempotent && retries > 0 {
+		header.Set(server.IdempotencyKeyHeader, newKey())
+	}
+
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader = bytes.NewReader(body)
+		if req.stream != nil {
+			reqBody = req.stream
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), reqBody)
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header = header.Clone()
+
+		resp, err := c.http.Do(httpReq)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			defer resp.Body.Close()
+			if out == nil {
+				return resp, nil
+			}
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil && err != io.EOF {
+				return resp, fmt.Errorf("can't decode the response: %v", err)
+			}
+			return resp, nil
+		}
+
+		if err == nil {
+			err = decodeError(resp)
+		}
+		if attempt >= retries || !retryable(ctx, err) {
+			return resp, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return resp, ctx.Err()
+		case <-time.After(c.backoff(attempt, resp)):
+		}
+	}
+}
+
+func (c *Client) header() http.Header {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	if c.cfg.Token != "" {
+		header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	if c.cfg.APIKey != "" {
+		header.Set(apikey.APIKeyHeader, c.cfg.APIKey)
+	}
+	if c.cfg.Tenant != "" {
+		header.Set(server.TenantHeader, c.cfg.Tenant)
+	}
+	if c.cfg.UserAgent != "" {
+		header.Set("User-Agent", c.cfg.UserAgent)
+	}
+	return header
+}
+
+// backoff is the wait before the retry after the attempt, the Retry-After of the response
+// when it has one.
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	wait := c.cfg.RetryBackoff << uint(attempt)
+	if wait <= 0 || wait > maxRetryBackoff {
+		wait = maxRetryBackoff
+	}
+	return wait
+}
+
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		// the request didn't get a response
+		return true
+	}
+
+	switch apiErr.Status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return apiErr.Code == server.ErrorCodeIdempotencyKeyInProgress
+}
+
+func newKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// decodeError reads the error response, the messages are the body itself when it isn't an
+// error response, e.g. the one of a proxy.
+func decodeError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	apiErr := &Error{Status: resp.StatusCode, RequestID: resp.Header.Get(server.RequestIDHeader)}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return apiErr
+	}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = ""
+		if message := strings.TrimSpace(string(body)); message != "" {
+			apiErr.Messages = []string{message}
+		}
+	}
+	return apiErr
+}
@@ -0,0 +1,236 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/users-api/cmd/server"
+	"github.com/users-api/pkg/user"
+)
+
+// tokenAuthenticator accepts a single bearer token granting every users scope and any tenant.
+type tokenAuthenticator struct {
+	token string
+}
+
+func (a tokenAuthenticator) Scheme() string {
+	return "Bearer"
+}
+
+func (a tokenAuthenticator) Authenticate(r *http.Request) (server.Claims, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, server.ErrNoCredentials
+	}
+	if header != "Bearer "+a.token {
+		return nil, &server.AuthError{Scheme: a.Scheme(), Message: "invalid token"}
+	}
+	return server.Claims{
+		"sub":   "client-test",
+		"scope": strings.Join([]string{user.ScopeRead, user.ScopeWrite, user.ScopeDelete, user.ScopeLocation, server.ScopeAnyTenant}, " "),
+	}, nil
+}
+
+// newTestClient serves the user routes with the real handlers and service on the mock.
+// The first failures requests to the server get a 503.
+func newTestClient(t *testing.T, repository *user.RepositoryMock, failures int32, c Config) *Client {
+	s := server.New(&server.Config{})
+	s.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	s.Use(s.Authentication(tokenAuthenticator{token: "token"}))
+	s.Use(s.Tenancy(server.TenancyConfig{DefaultTenant: "default"}))
+	s.Use(server.Idempotency(server.NewMemoryIdempotencyStore(), time.Hour))
+	user.RegisterRoutes(s, user.NewServiceWithRepository(repository))
+
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	c.BaseURL = ts.URL
+	if c.Token == "" {
+		c.Token = "token"
+	}
+	client, err := New(c)
+	assert.Nil(t, err)
+	return client
+}
+
+func TestClient_Create(t *testing.T) {
+	repository := &user.RepositoryMock{}
+	repository.On("WithTx", mock.Anything).Return(nil)
+	repository.On("Insert", mock.MatchedBy(func(u *user.User) bool { return u.Name == "Jhon" })).Return(int64(7), nil).Once()
+	repository.On("InsertAudit", mock.Anything).Return(nil)
+
+	client := newTestClient(t, repository, 1, Config{Tenant: "acme", Retries: 2, RetryBackoff: time.Millisecond})
+
+	id, err := client.Create(context.Background(), user.UserRequest{Name: "Jhon", Address: "Main St"})
+	assert.Nil(t, err)
+	assert.Equal(t, id, int64(7))
+	assert.Equal(t, repository.Tenant, "acme")
+	repository.AssertExpectations(t)
+
+	_, err = client.Create(context.Background(), user.UserRequest{Name: "Jo"})
+	assert.True(t, errors.Is(err, ErrInvalidParams))
+	var apiErr *Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, apiErr.Status, http.StatusBadRequest)
+}
+
+func TestClient_Get(t *testing.T) {
+	repository := &user.RepositoryMock{}
+	repository.On("Get", 1).Return(user.User{Id: 1, Name: "Jhon"}, nil)
+	repository.On("Get", 2).Return(nil, &user.NotFoundError{Message: errors.New("user 2 not found")})
+	repository.On("GetSurvivor", 2).Return(0, nil)
+	repository.On("Get", 3).Return(nil, &user.NotFoundError{Message: errors.New("user 3 not found")})
+	repository.On("GetSurvivor", 3).Return(1, nil)
+
+	client := newTestClient(t, repository, 0, Config{})
+
+	u, err := client.Get(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, u.Name, "Jhon")
+
+	_, err = client.Get(context.Background(), 2)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Contains(t, err.Error(), "user 2 not found")
+
+	u, err = client.Get(context.Background(), 3)
+	assert.Nil(t, err)
+	assert.Equal(t, u.Id, 1)
+}
+
+func TestClient_UpdatePatchDelete(t *testing.T) {
+	repository := &user.RepositoryMock{}
+	repository.On("WithTx", mock.Anything).Return(nil)
+	repository.On("GetForUpdate", 1).Return(user.User{Id: 1, Name: "Jhon", Address: "Main St"}, nil)
+	repository.On("Update", 1, mock.Anything).Return(nil)
+	repository.On("InsertAudit", mock.Anything).Return(nil)
+	repository.On("Delete", 1).Return(nil)
+
+	client := newTestClient(t, repository, 0, Config{Retries: 1})
+	ctx := context.Background()
+
+	assert.Nil(t, client.Update(ctx, 1, user.UserRequest{Name: "Jhon Doe"}))
+
+	address := "Second St"
+	u, err := client.Patch(ctx, 1, user.PatchRequest{Address: &address})
+	assert.Nil(t, err)
+	assert.Equal(t, u.Name, "Jhon")
+	assert.Equal(t, u.Address, "Second St")
+
+	assert.Nil(t, client.Delete(ctx, 1))
+}
+
+func TestClient_Find(t *testing.T) {
+	next := user.Cursor{Id: 2}
+	repository := &user.RepositoryMock{}
+	repository.On("Find", mock.MatchedBy(func(f user.Filter) bool { return f.Cursor == nil && f.Name == "Jhon" })).
+		Return(user.UserList{Data: []user.User{{Id: 1}, {Id: 2}}, Size: 2, HasMore: true, NextCursor: next.Encode()}, nil).Once()
+	repository.On("Find", mock.MatchedBy(func(f user.Filter) bool { return f.Cursor != nil && f.Cursor.Id == 2 })).
+		Return(user.UserList{Data: []user.User{{Id: 3}}, Size: 2}, nil).Once()
+
+	client := newTestClient(t, repository, 0, Config{})
+
+	users := client.Find(context.Background(), FindOptions{Name: "Jhon", Size: 2})
+	var ids []int
+	for users.Next() {
+		ids = append(ids, users.User().Id)
+	}
+	assert.Nil(t, users.Err())
+	assert.Equal(t, ids, []int{1, 2, 3})
+	repository.AssertExpectations(t)
+
+	users = client.Find(context.Background(), FindOptions{})
+	assert.False(t, users.Next())
+	assert.True(t, errors.Is(users.Err(), ErrInvalidParams))
+}
+
+func TestClient_Import(t *testing.T) {
+	repository := &user.RepositoryMock{}
+	repository.On("WithTx", mock.Anything).Return(nil)
+	repository.On("InsertMany", mock.Anything).Return([]int64{7}, nil).Once()
+	repository.On("InsertAudit", mock.Anything).Return(nil)
+
+	// the file can only be sent once, the 503 of the first request is not retried
+	client := newTestClient(t, repository, 1, Config{Retries: 2, RetryBackoff: time.Millisecond})
+	_, err := client.Import(context.Background(), strings.NewReader("name\nJhon\n"), ImportOptions{Format: user.ImportFormatCSV})
+	var apiErr *Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, apiErr.Status, http.StatusServiceUnavailable)
+
+	file := "full_name,birth\nJhon,1990-12-31\nJo,\n"
+	options := ImportOptions{Format: user.ImportFormatCSV, Columns: map[string]string{"full_name": "name", "birth": "dob"}}
+	report, err := client.Import(context.Background(), strings.NewReader(file), options)
+	assert.Nil(t, err)
+	assert.Equal(t, report.Accepted, 1)
+	assert.Equal(t, report.Errors[0].Line, 3)
+
+	options.DryRun = true
+	report, err = client.Import(context.Background(), strings.NewReader(file), options)
+	assert.Nil(t, err)
+	assert.Equal(t, report.DryRun, true)
+	assert.Equal(t, report.Accepted, 1)
+	repository.AssertExpectations(t)
+
+	_, err = client.Import(context.Background(), strings.NewReader(file), ImportOptions{Format: "xlsx"})
+	assert.EqualError(t, err, `"xlsx" is not an import format, use csv or ndjson`)
+}
+
+func TestClient_GetLocation(t *testing.T) {
+	repository := &user.RepositoryMock{}
+	repository.On("GetLocation", 1).Return(user.Location{Type: "FeatureCollection", Query: []string{"main", "st"}}, nil)
+
+	client := newTestClient(t, repository, 0, Config{})
+
+	location, err := client.GetLocation(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, location.Type, "FeatureCollection")
+}
+
+func TestClient_Errors(t *testing.T) {
+	repository := &user.RepositoryMock{}
+	repository.On("Get", 1).Return(user.User{Id: 1}, nil)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		client := newTestClient(t, repository, 0, Config{Token: "wrong"})
+		_, err := client.Get(context.Background(), 1)
+		assert.True(t, errors.Is(err, ErrUnauthorized))
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		client := newTestClient(t, repository, 3, Config{Retries: 2, RetryBackoff: time.Millisecond})
+		_, err := client.Get(context.Background(), 1)
+		assert.True(t, errors.Is(err, &Error{Status: http.StatusServiceUnavailable}))
+		assert.Contains(t, err.Error(), "unavailable")
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		client := newTestClient(t, repository, 0, Config{})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := client.Get(ctx, 1)
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+
+	t.Run("config", func(t *testing.T) {
+		_, err := New(Config{})
+		assert.NotNil(t, err)
+		_, err = New(Config{BaseURL: "http://localhost", Token: "token", APIKey: "key"})
+		assert.NotNil(t, err)
+	})
+}
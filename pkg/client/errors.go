@@ -0,0 +1,60 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/users-api/cmd/server"
+	"github.com/users-api/pkg/apikey"
+	"github.com/users-api/pkg/user"
+)
+
+// Error is an error response of the api. It matches, with errors.Is, the Err* value of its code.
+type Error struct {
+	Status    int      `json:"-"`
+	Code      string   `json:"code"`
+	Messages  []string `json:"messages"`
+	RequestID string   `json:"-"`
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("users-api: %d %s", e.Status, http.StatusText(e.Status))
+	if e.Code != "" {
+		message += " " + e.Code
+	}
+	if len(e.Messages) > 0 {
+		message += ": " + strings.Join(e.Messages, ", ")
+	}
+	return message
+}
+
+// Is matches the errors with the same code, the target status is only checked when it has no code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	if t.Code != "" {
+		return e.Code == t.Code
+	}
+	return t.Status != 0 && e.Status == t.Status
+}
+
+// The errors of the codes the api answers with, e.g. errors.Is(err, client.ErrNotFound).
+var (
+	ErrInvalidParams          = &Error{Code: user.ErrorCodeInvalidParams}
+	ErrNotFound               = &Error{Code: "NOT_FOUND"}
+	ErrUnauthorized           = &Error{Code: server.ErrorCodeUnauthorized}
+	ErrInsufficientScope      = &Error{Code: server.ErrorCodeInsufficientScope}
+	ErrKeyRevoked             = &Error{Code: apikey.ErrorCodeKeyRevoked}
+	ErrRateLimited            = &Error{Code: server.ErrorCodeRateLimited}
+	ErrRequestTooLarge        = &Error{Code: server.ErrorCodeRequestTooLarge}
+	ErrUnsupportedMediaType   = &Error{Code: server.ErrorCodeUnsupportedMediaType}
+	ErrIdempotencyKeyReused   = &Error{Code: server.ErrorCodeIdempotencyKeyReused}
+	ErrIdempotencyKeyInFlight = &Error{Code: server.ErrorCodeIdempotencyKeyInProgress}
+	ErrTenantRequired         = &Error{Code: server.ErrorCodeTenantRequired}
+	ErrTenantInvalid          = &Error{Code: server.ErrorCodeTenantInvalid}
+	ErrTenantMismatch         = &Error{Code: server.ErrorCodeTenantMismatch}
+	ErrInternal               = &Error{Code: "INTERNAL_SERVER_ERROR"}
+)
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/users-api/pkg/user"
+)
+
+// Create creates the user and returns its id.
+func (c *Client) Create(ctx context.Context, request user.UserRequest) (int64, error) {
+	resp, err := c.do(ctx, call{method: http.MethodPost, path: "/users", body: request, idempotent: true}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	location := resp.Header.Get("Location")
+	id, err := strconv.ParseInt(path.Base(location), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("can't read the id of the user from the location %q", location)
+	}
+	return id, nil
+}
+
+// Get returns the user, only with the given fields when there are any. The user a merged user
+// was merged into is returned in its place.
+func (c *Client) Get(ctx context.Context, id int, fields ...string) (user.User, error) {
+	query := url.Values{}
+	if len(fields) > 0 {
+		query.Set("fields", strings.Join(fields, ","))
+	}
+
+	var u user.User
+	_, err := c.do(ctx, call{method: http.MethodGet, path: userPath(id), query: query}, &u)
+	return u, err
+}
+
+// Update replaces the user.
+func (c *Client) Update(ctx context.Context, id int, request user.UserRequest) error {
+	_, err := c.do(ctx, call{method: http.MethodPut, path: userPath(id), body: request}, nil)
+	return err
+}
+
+// Patch changes the fields set in the request and returns the user changed.
+func (c *Client) Patch(ctx context.Context, id int, request user.PatchRequest) (user.User, error) {
+	var u user.User
+	_, err := c.do(ctx, call{method: http.MethodPatch, path: userPath(id), body: request, idempotent: true}, &u)
+	return u, err
+}
+
+func (c *Client) Delete(ctx context.Context, id int) error {
+	_, err := c.do(ctx, call{method: http.MethodDelete, path: userPath(id)}, nil)
+	return err
+}
+
+func (c *Client) GetLocation(ctx context.Context, id int) (user.Location, error) {
+	var location user.Location
+	_, err := c.do(ctx, call{method: http.MethodGet, path: userPath(id) + "/locations"}, &location)
+	return location, err
+}
+
+// ImportOptions tells how to read the file of an import.
+type ImportOptions struct {
+	// Format of the file, user.ImportFormatCSV or user.ImportFormatNDJSON
+	Format string
+	// Columns maps the columns of a CSV file to the fields, e.g. "full_name": "name"; the columns
+	// named like a field don't need to be mapped
+	Columns map[string]string
+	// DryRun validates the rows without inserting them
+	DryRun bool
+}
+
+// Import streams the file to the api, which inserts the valid rows and reports the rejected
+// ones. The request is not retried since the file can only be read once.
+func (c *Client) Import(ctx context.Context, file io.Reader, options ImportOptions) (user.ImportReport, error) {
+	var contentType string
+	switch options.Format {
+	case user.ImportFormatCSV:
+		contentType = "text/csv"
+	case user.ImportFormatNDJSON:
+		contentType = "application/x-ndjson"
+	default:
+		return user.ImportReport{}, fmt.Errorf("%q is not an import format, use csv or ndjson", options.Format)
+	}
+
+	query := url.Values{}
+	if options.DryRun {
+		query.Set("dry_run", "true")
+	}
+	if len(options.Columns) > 0 {
+		pairs := make([]string, 0, len(options.Columns))
+		for column, field := range options.Columns {
+			pairs = append(pairs, column+":"+field)
+		}
+		sort.Strings(pairs)
+		query.Set("columns", strings.Join(pairs, ","))
+	}
+
+	var report user.ImportReport
+	_, err := c.do(ctx, call{method: http.MethodPost, path: "/users/import", query: query, stream: file, contentType: contentType}, &report)
+	return report, err
+}
+
+func userPath(id int) string {
+	return "/users/" + strconv.Itoa(id)
+}
+
+// FindOptions filters the users listed by Find.
+type FindOptions struct {
+	Name string
+	// Size of the pages, the api default when zero
+	Size   int
+	Fields []string
+}
+
+// Find lists the users with the name, the pages are fetched as the iterator advances:
+//
+//	users := c.Find(ctx, client.FindOptions{Name: "Jhon"})
+//	for users.Next() {
+//		fmt.Println(users.User().Id)
+//	}
+//	if err := users.Err(); err != nil {
+//		...
+//	}
+func (c *Client) Find(ctx context.Context, options FindOptions) *UserIterator {
+	query := url.Values{}
+	query.Set("name", options.Name)
+	if options.Size > 0 {
+		query.Set("size", strconv.Itoa(options.Size))
+	}
+	if len(options.Fields) > 0 {
+		query.Set("fields", strings.Join(options.Fields, ","))
+	}
+
+	return &UserIterator{client: c, ctx: ctx, query: query, more: true}
+}
+
+// UserIterator walks the users of a listing page by page, following the cursors of the pages.
+type UserIterator struct {
+	client *Client
+	ctx    context.Context
+	query  url.Values
+	page   user.UserList
+	index  int
+	more   bool
+	err    error
+}
+
+// Next advances to the next user, fetching the next page when needed. It returns false at
+// the end of the listing or on an error, see Err.
+func (it *UserIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	for it.index >= len(it.page.Data) {
+		if !it.more {
+			return false
+		}
+		if it.err = it.fetch(); it.err != nil {
+			return false
+		}
+	}
+
+	it.index++
+	return true
+}
+
+// User is the current user, valid after Next returned true.
+func (it *UserIterator) User() user.User {
+	return it.page.Data[it.index-1]
+}
+
+// Page is the last page fetched.
+func (it *UserIterator) Page() user.UserList {
+	return it.page
+}
+
+// Err is the error that stopped the iteration, nil at the end of the listing.
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+func (it *UserIterator) fetch() error {
+	var page user.UserList
+	if _, err := it.client.do(it.ctx, call{method: http.MethodGet, path: "/users", query: it.query}, &page); err != nil {
+		return err
+	}
+
+	it.page, it.index = page, 0
+
+	switch {
+	case !page.HasMore || len(page.Data) == 0:
+		it.more = false
+	case page.NextCursor != "":
+		it.query.Del("offset")
+		it.query.Set("cursor", page.NextCursor)
+	default:
+		it.query.Set("offset", strconv.Itoa(page.Offset+len(page.Data)))
+	}
+	return nil
+}
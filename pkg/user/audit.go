@@ -0,0 +1,94 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/users-api/cmd/server"
+)
+
+const auditDateLayout = "2006-01-02"
+
+// auditFields are the fields whose changes are audited.
+var auditFields = []string{"name", "address", "dob"}
+
+// newAuditEntry builds the entry of a change made in the request the context belongs to.
+// Before is nil for creates and after is nil for deletes.
+func newAuditEntry(ctx context.Context, userId int, action string, before *User, after *User) *AuditEntry {
+	return &AuditEntry{
+		UserId:    userId,
+		Action:    action,
+		Actor:     server.GetActor(ctx),
+		RequestId: server.GetRequestID(ctx),
+		Changes:   diff(before, after),
+	}
+}
+
+// diff returns the audited fields whose value differs between before and after.
+func diff(before *User, after *User) Changes {
+	changes := Changes{}
+	for _, field := range auditFields {
+		var from, to *string
+		if before != nil {
+			value := auditValue(*before, field)
+			from = &value
+		}
+		if after != nil {
+			value := auditValue(*after, field)
+			to = &value
+		}
+
+		if from == nil || to == nil || *from != *to {
+			changes[field] = Change{Before: from, After: to}
+		}
+	}
+	return changes
+}
+
+func auditValue(user User, field string) string {
+	switch field {
+	case "name":
+		return user.Name
+	case "address":
+		return user.Address
+	default:
+		return user.Dob.Format(auditDateLayout)
+	}
+}
+
+func restoreValue(user *User, field string, value string) {
+	switch field {
+	case "name":
+		user.Name = value
+	case "address":
+		user.Address = value
+	case "dob":
+		user.Dob, _ = time.Parse(auditDateLayout, value)
+	}
+}
+
+// rewind undoes the entries, newest first, starting from the current user or nil when it
+// does not exist anymore. It returns nil when the user did not exist before the entries.
+// Only the audited fields are restored.
+func rewind(userId int, current *User, entries []AuditEntry) *User {
+	state := current
+	for _, entry := range entries {
+		switch entry.Action {
+		case AuditCreate:
+			state = nil
+		case AuditDelete:
+			state = &User{Id: userId}
+			fallthrough
+		case AuditUpdate:
+			if state == nil {
+				continue
+			}
+			for field, change := range entry.Changes {
+				if change.Before != nil {
+					restoreValue(state, field, *change.Before)
+				}
+			}
+		}
+	}
+	return state
+}
@@ -0,0 +1,44 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	dob := time.Date(1991, 1, 10, 0, 0, 0, 0, time.UTC)
+	before := &User{Name: "Jhon", Address: "5th avenue", Dob: dob}
+	after := &User{Name: "Jhon", Address: "6th avenue", Dob: dob}
+
+	changes := diff(before, after)
+
+	assert.Len(t, changes, 1)
+	assert.Equal(t, *changes["address"].Before, "5th avenue")
+	assert.Equal(t, *changes["address"].After, "6th avenue")
+
+	created := diff(nil, after)
+	assert.Len(t, created, 3)
+	assert.Nil(t, created["dob"].Before)
+	assert.Equal(t, *created["dob"].After, "1991-01-10")
+}
+
+func TestRewind(t *testing.T) {
+	dob := time.Date(1991, 1, 10, 0, 0, 0, 0, time.UTC)
+	created := &User{Name: "Jhon", Address: "5th avenue", Dob: dob}
+	updated := &User{Name: "Jhon", Address: "6th avenue", Dob: dob}
+
+	entries := []AuditEntry{
+		{Action: AuditDelete, Changes: diff(updated, nil)},
+		{Action: AuditUpdate, Changes: diff(created, updated)},
+		{Action: AuditCreate, Changes: diff(nil, created)},
+	}
+
+	assert.Equal(t, rewind(1, nil, entries[:1]), &User{Id: 1, Name: "Jhon", Address: "6th avenue", Dob: dob})
+	assert.Equal(t, rewind(1, nil, entries[:2]), &User{Id: 1, Name: "Jhon", Address: "5th avenue", Dob: dob})
+	assert.Nil(t, rewind(1, nil, entries))
+
+	current := *updated
+	assert.Equal(t, rewind(1, &current, entries[1:2]).Address, "5th avenue")
+}
@@ -0,0 +1,77 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ErrInvalidCursor is returned when a pagination token is malformed or its signature does not match.
+var ErrInvalidCursor = errors.New("the cursor is invalid")
+
+// Cursor is the position of a row in the (created_at, id) ordering used by keyset pagination.
+// Backward cursors read the page that precedes the position instead of the one that follows it.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	Id        int       `json:"i"`
+	Backward  bool      `json:"b,omitempty"`
+}
+
+func newCursor(user User, backward bool) *Cursor {
+	return &Cursor{CreatedAt: user.CreatedAt, Id: user.Id, Backward: backward}
+}
+
+// LastPageCursor points to the page that ends with the last row of the listing.
+func LastPageCursor() *Cursor {
+	return &Cursor{Backward: true}
+}
+
+func (c *Cursor) atEnd() bool {
+	return c.Backward && c.Id == 0 && c.CreatedAt.IsZero()
+}
+
+// Encode returns the opaque token sent to clients: the base64 payload followed by its HMAC signature.
+func (c *Cursor) Encode() string {
+	payload, _ := json.Marshal(c)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signCursor(encoded))
+}
+
+// DecodeCursor verifies and parses a token previously built with Cursor.Encode.
+func DecodeCursor(token string) (*Cursor, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, signCursor(parts[0])) {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+func signCursor(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(viper.GetString("pagination.cursor_secret")))
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,63 @@
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor_EncodeDecode(t *testing.T) {
+	viper.Set("pagination.cursor_secret", "secret")
+
+	cursor := &Cursor{CreatedAt: time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC), Id: 7, Backward: true}
+
+	tests := []struct {
+		name        string
+		token       string
+		assertError func(*testing.T, error)
+		assertFunc  func(*testing.T, *Cursor)
+	}{
+		{
+			name:  "Success - valid token",
+			token: cursor.Encode(),
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, c *Cursor) {
+				assert.True(t, c.CreatedAt.Equal(cursor.CreatedAt))
+				assert.Equal(t, c.Id, 7)
+				assert.True(t, c.Backward)
+			},
+		},
+		{
+			name:  "Error - tampered payload",
+			token: strings.Split((&Cursor{Id: 8}).Encode(), ".")[0] + "." + strings.Split(cursor.Encode(), ".")[1],
+			assertError: func(t *testing.T, e error) {
+				assert.Equal(t, e, ErrInvalidCursor)
+			},
+			assertFunc: func(t *testing.T, c *Cursor) {
+				assert.Nil(t, c)
+			},
+		},
+		{
+			name:  "Error - malformed token",
+			token: "not-a-cursor",
+			assertError: func(t *testing.T, e error) {
+				assert.Equal(t, e, ErrInvalidCursor)
+			},
+			assertFunc: func(t *testing.T, c *Cursor) {
+				assert.Nil(t, c)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := DecodeCursor(tt.token)
+			tt.assertError(t, err)
+			tt.assertFunc(t, c)
+		})
+	}
+}
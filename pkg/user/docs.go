@@ -0,0 +1,154 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/users-api/cmd/server"
+)
+
+// Docs of the routes in the OpenAPI document.
+
+var (
+	minPageSize, maxPageSize, minOffset = 1, MaxPageSize, 0
+
+	idParam     = server.Param{Name: "id", In: "path", Type: "integer", Description: "Id of the user"}
+	sizeParam   = server.Param{Name: "size", Type: "integer", Minimum: &minPageSize, Maximum: &maxPageSize, Description: "Size of the page"}
+	offsetParam = server.Param{Name: "offset", Type: "integer", Minimum: &minOffset, Description: "Position of the first user of the page"}
+	fieldsParam = server.Param{Name: "fields", Description: "Comma separated fields to return: id,name,address,dob,created_at,updated_at"}
+
+	idempotentErrors = []int{http.StatusConflict, http.StatusUnprocessableEntity}
+)
+
+var createDoc = server.Doc{
+	Summary:   "Create a user",
+	Tags:      []string{"users"},
+	Params:    []server.Param{server.IdempotencyKeyParam},
+	Request:   UserRequest{},
+	Responses: map[int]interface{}{http.StatusCreated: nil},
+	Errors:    idempotentErrors,
+}
+
+var batchDoc = server.Doc{
+	Summary: "Create, update and delete users in a batch",
+	Description: "The operations run in a single transaction, or one by one in best_effort mode. " +
+		"Deletes need the users:delete scope.",
+	Tags:      []string{"users"},
+	Params:    []server.Param{server.IdempotencyKeyParam},
+	Request:   BatchRequest{},
+	Responses: map[int]interface{}{http.StatusOK: BatchResponse{}, http.StatusMultiStatus: BatchResponse{}},
+	Errors:    idempotentErrors,
+}
+
+var importDoc = server.Doc{
+	Summary: "Import users from a CSV or NDJSON file",
+	Description: "A CSV file starts with a header, its columns are read into the fields of their name " +
+		"or the ones of the columns param. Every row is validated, the valid ones are inserted in chunks " +
+		"and the report lists the line and errors of the rejected ones.",
+	Tags: []string{"users"},
+	Params: []server.Param{
+		{Name: "dry_run", Type: "boolean", Description: "Validate the rows without inserting them"},
+		{Name: "columns", Description: "Comma separated column:field pairs of a CSV file, e.g. full_name:name,birth_date:dob"},
+	},
+	Consumes:  []string{"text/csv", "application/x-ndjson"},
+	Responses: map[int]interface{}{http.StatusOK: ImportReport{}},
+}
+
+var searchDoc = server.Doc{
+	Summary: "Search users by name and address",
+	Tags:    []string{"users"},
+	Params: []server.Param{
+		{Name: "q", Required: true, Description: "Terms to search"},
+		sizeParam,
+		offsetParam,
+	},
+	Responses: map[int]interface{}{http.StatusOK: SearchList{}},
+	Errors:    []int{http.StatusBadRequest},
+}
+
+var updateDoc = server.Doc{
+	Summary:   "Replace a user",
+	Tags:      []string{"users"},
+	Params:    []server.Param{idParam},
+	Request:   UserRequest{},
+	Responses: map[int]interface{}{http.StatusOK: nil},
+	Errors:    []int{http.StatusNotFound},
+}
+
+var patchDoc = server.Doc{
+	Summary:   "Change the fields sent of a user",
+	Tags:      []string{"users"},
+	Params:    []server.Param{idParam, server.IdempotencyKeyParam},
+	Request:   PatchRequest{},
+	Responses: map[int]interface{}{http.StatusOK: User{}},
+	Errors:    append([]int{http.StatusNotFound}, idempotentErrors...),
+}
+
+var getDoc = server.Doc{
+	Summary:     "Get a user",
+	Description: "A merged user redirects to the user it was merged into.",
+	Tags:        []string{"users"},
+	Params: []server.Param{
+		idParam,
+		fieldsParam,
+		{Name: "as_of", Format: "date-time", Description: "Return the user as it was at this RFC 3339 time"},
+	},
+	Responses: map[int]interface{}{http.StatusOK: User{}},
+	Errors:    []int{http.StatusMovedPermanently, http.StatusBadRequest, http.StatusNotFound},
+}
+
+var findDoc = server.Doc{
+	Summary:     "List the users with a name",
+	Description: "The Link header has the first, prev, next and last pages.",
+	Tags:        []string{"users"},
+	Params: []server.Param{
+		{Name: "name", Required: true, Description: "Name of the users"},
+		sizeParam,
+		offsetParam,
+		{Name: "cursor", Description: "Cursor of the page, replaces offset"},
+		{Name: "include_total", Enum: []string{"false", "true", "exact", "estimate"}, Description: "Count the users exactly or estimate the count"},
+		fieldsParam,
+	},
+	Responses: map[int]interface{}{http.StatusOK: UserList{}},
+	Errors:    []int{http.StatusBadRequest},
+}
+
+var deleteDoc = server.Doc{
+	Summary:   "Delete a user",
+	Tags:      []string{"users"},
+	Params:    []server.Param{idParam},
+	Responses: map[int]interface{}{http.StatusOK: nil},
+	Errors:    []int{http.StatusNotFound},
+}
+
+var historyDoc = server.Doc{
+	Summary:   "List the changes of a user, newest first",
+	Tags:      []string{"users"},
+	Params:    []server.Param{idParam, sizeParam, offsetParam},
+	Responses: map[int]interface{}{http.StatusOK: History{}},
+	Errors:    []int{http.StatusBadRequest, http.StatusNotFound},
+}
+
+var duplicatesDoc = server.Doc{
+	Summary:   "List the users that are likely the same person",
+	Tags:      []string{"users"},
+	Params:    []server.Param{idParam},
+	Responses: map[int]interface{}{http.StatusOK: DuplicateList{}},
+	Errors:    []int{http.StatusNotFound},
+}
+
+var mergeDoc = server.Doc{
+	Summary:   "Merge users into this one",
+	Tags:      []string{"users"},
+	Params:    []server.Param{idParam, server.IdempotencyKeyParam},
+	Request:   MergeRequest{},
+	Responses: map[int]interface{}{http.StatusOK: User{}},
+	Errors:    append([]int{http.StatusNotFound}, idempotentErrors...),
+}
+
+var locationDoc = server.Doc{
+	Summary:   "Get the location of the address of a user",
+	Tags:      []string{"users"},
+	Params:    []server.Param{idParam},
+	Responses: map[int]interface{}{http.StatusOK: Location{}},
+	Errors:    []int{http.StatusNotFound},
+}
@@ -0,0 +1,237 @@
+package user
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	PrecedenceSurvivor string = "survivor"
+	PrecedenceNewest   string = "newest"
+	PrecedenceOldest   string = "oldest"
+	PrecedenceLongest  string = "longest"
+
+	defaultDuplicateThreshold float64 = 0.7
+
+	nameWeight    float64 = 0.5
+	dobWeight     float64 = 0.2
+	addressWeight float64 = 0.3
+)
+
+// mergeFields are the fields combined by a merge, the rest belong to the survivor.
+var mergeFields = []string{"name", "address", "dob"}
+
+// scoreDuplicate compares two users on normalized name, dob and address, 1 being identical.
+func scoreDuplicate(user User, candidate User) Duplicate {
+	scores := map[string]float64{
+		"name":    similarity(normalize(user.Name), normalize(candidate.Name)),
+		"address": tokenSimilarity(normalize(user.Address), normalize(candidate.Address)),
+		"dob":     0,
+	}
+	if !user.Dob.IsZero() && user.Dob.Equal(candidate.Dob) {
+		scores["dob"] = 1
+	}
+
+	score := scores["name"]*nameWeight + scores["dob"]*dobWeight + scores["address"]*addressWeight
+
+	return Duplicate{User: candidate, Score: score, Scores: scores}
+}
+
+// rankDuplicates returns the candidates scoring at least duplicates.threshold, best first.
+func rankDuplicates(user User, candidates []User) []Duplicate {
+	threshold := viper.GetFloat64("duplicates.threshold")
+	if threshold <= 0 {
+		threshold = defaultDuplicateThreshold
+	}
+
+	duplicates := make([]Duplicate, 0)
+	for _, candidate := range candidates {
+		if duplicate := scoreDuplicate(user, candidate); duplicate.Score >= threshold {
+			duplicates = append(duplicates, duplicate)
+		}
+	}
+
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return duplicates[i].Score > duplicates[j].Score
+	})
+
+	return duplicates
+}
+
+// normalize lower cases the value, removes accents and punctuation and collapses spaces.
+func normalize(value string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), value)
+	if err != nil {
+		folded = value
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(folded), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+
+	return strings.Join(words, " ")
+}
+
+// similarity is one minus the edit distance between a and b relative to the longest of them.
+func similarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 0
+	}
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minimum(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return 1 - float64(previous[len(rb)])/float64(longest)
+}
+
+// tokenSimilarity is the Jaccard index of the words of a and b.
+func tokenSimilarity(a string, b string) float64 {
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+
+	set := make(map[string]bool, len(wordsA))
+	for _, word := range wordsA {
+		set[word] = true
+	}
+
+	shared, union := 0, len(set)
+	seen := make(map[string]bool, len(wordsB))
+	for _, word := range wordsB {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		if set[word] {
+			shared++
+		} else {
+			union++
+		}
+	}
+
+	return float64(shared) / float64(union)
+}
+
+func minimum(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
+
+// precedences returns the merge strategy of every field: the request first, then
+// merge.precedence.<field> and survivor by default.
+func precedences(requested map[string]string) (map[string]string, error) {
+	result := make(map[string]string, len(mergeFields))
+	for field, precedence := range requested {
+		if !contains(mergeFields, field) {
+			return nil, fmt.Errorf("%s can't be merged, use %s", field, strings.Join(mergeFields, ","))
+		}
+		result[field] = precedence
+	}
+
+	for _, field := range mergeFields {
+		if _, ok := result[field]; !ok {
+			result[field] = viper.GetString("merge.precedence." + field)
+		}
+		if result[field] == "" {
+			result[field] = PrecedenceSurvivor
+		}
+
+		switch result[field] {
+		case PrecedenceSurvivor, PrecedenceNewest, PrecedenceOldest, PrecedenceLongest:
+		default:
+			return nil, fmt.Errorf("%s is not a valid precedence, use survivor, newest, oldest or longest", result[field])
+		}
+	}
+
+	return result, nil
+}
+
+// mergeUsers combines the users into the survivor, picking each field from the user the
+// precedence prefers among the ones that have a value. Longest only applies to text fields.
+func mergeUsers(survivor User, merged []User, precedence map[string]string) User {
+	users := append([]User{survivor}, merged...)
+	result := survivor
+
+	for _, field := range mergeFields {
+		candidates := make([]User, 0, len(users))
+		for _, user := range users {
+			if !emptyField(user, field) {
+				candidates = append(candidates, user)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		switch precedence[field] {
+		case PrecedenceNewest:
+			sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].UpdatedAt.After(candidates[j].UpdatedAt) })
+		case PrecedenceOldest:
+			sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].CreatedAt.Before(candidates[j].CreatedAt) })
+		case PrecedenceLongest:
+			if field != "dob" {
+				sort.SliceStable(candidates, func(i, j int) bool {
+					return len(fieldValue(candidates[i], field)) > len(fieldValue(candidates[j], field))
+				})
+			}
+		}
+
+		switch field {
+		case "name":
+			result.Name = candidates[0].Name
+		case "address":
+			result.Address = candidates[0].Address
+		case "dob":
+			result.Dob = candidates[0].Dob
+		}
+	}
+
+	return result
+}
+
+func emptyField(user User, field string) bool {
+	if field == "dob" {
+		return user.Dob.IsZero()
+	}
+	return fieldValue(user, field) == ""
+}
+
+func fieldValue(user User, field string) string {
+	if field == "name" {
+		return user.Name
+	}
+	return user.Address
+}
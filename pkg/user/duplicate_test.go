@@ -0,0 +1,46 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankDuplicates(t *testing.T) {
+	dob := time.Date(1991, 1, 10, 0, 0, 0, 0, time.UTC)
+	user := User{Id: 1, Name: "Jhon", Address: "5th avenue", Dob: dob}
+
+	duplicates := rankDuplicates(user, []User{
+		{Id: 2, Name: "Peter", Address: "Main street", Dob: dob},
+		{Id: 3, Name: "John", Address: "5th Avenue.", Dob: dob},
+		{Id: 4, Name: "Jhón", Address: "5th avenue", Dob: dob},
+	})
+
+	assert.Equal(t, len(duplicates), 2)
+	assert.Equal(t, duplicates[0].User.Id, 4)
+	assert.Equal(t, duplicates[0].Score, 1.0)
+	assert.Equal(t, duplicates[1].User.Id, 3)
+	assert.Equal(t, duplicates[1].Scores["address"], 1.0)
+}
+
+func TestMergeUsers(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	survivor := User{Id: 1, Name: "Jhon", Address: "5th avenue", UpdatedAt: old, CreatedAt: old}
+	merged := []User{
+		{Id: 2, Name: "Jhon Smith", Address: "6th avenue", Dob: old, UpdatedAt: old.AddDate(1, 0, 0), CreatedAt: old},
+	}
+
+	precedence, err := precedences(map[string]string{"name": PrecedenceLongest, "address": PrecedenceNewest, "dob": PrecedenceSurvivor})
+	assert.Nil(t, err)
+
+	result := mergeUsers(survivor, merged, precedence)
+
+	assert.Equal(t, result.Id, 1)
+	assert.Equal(t, result.Name, "Jhon Smith")
+	assert.Equal(t, result.Address, "6th avenue")
+	assert.Equal(t, result.Dob, old)
+
+	_, err = precedences(map[string]string{"id": PrecedenceNewest})
+	assert.NotNil(t, err)
+}
@@ -1,21 +1,263 @@
 package user
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
+	"strings"
 	"time"
 )
 
 type UserList struct {
-	Data   []User `json:"data"`
-	Size   int    `json:"size"`
-	Offset int    `json:"offset"`
+	Data           []User `json:"data"`
+	Size           int    `json:"size"`
+	Offset         int    `json:"offset"`
+	HasMore        bool   `json:"has_more"`
+	TotalCount     *int64 `json:"total_count,omitempty"`
+	TotalEstimated bool   `json:"total_estimated,omitempty"`
+	NextCursor     string `json:"next_cursor,omitempty"`
+	PrevCursor     string `json:"prev_cursor,omitempty"`
+}
+
+// SearchResult is a user matched by a full-text search, with its relevance and the
+// matching fragments of each field wrapped in <em> tags.
+type SearchResult struct {
+	User
+	Score      float64           `db:"score" json:"score"`
+	Highlights map[string]string `db:"-" json:"highlights,omitempty"`
+}
+
+type SearchList struct {
+	Data   []SearchResult `json:"data"`
+	Size   int            `json:"size"`
+	Offset int            `json:"offset"`
+}
+
+// Duplicate is a user that is likely the same person as another one. Scores holds the
+// similarity of each compared field, from 0 to 1.
+type Duplicate struct {
+	User   User               `json:"user"`
+	Score  float64            `json:"score"`
+	Scores map[string]float64 `json:"scores"`
+}
+
+type DuplicateList struct {
+	Data []Duplicate `json:"data"`
+}
+
+// MergeRequest lists the users merged into the survivor. Precedence overrides, per field,
+// which user the value is taken from: survivor, newest, oldest or longest.
+type MergeRequest struct {
+	Ids        []int             `json:"ids"`
+	Precedence map[string]string `json:"precedence"`
+}
+
+const (
+	AuditCreate string = "create"
+	AuditUpdate string = "update"
+	AuditDelete string = "delete"
+)
+
+// AuditEntry records a change made to a user, who made it and in which request.
+type AuditEntry struct {
+	Id        int64     `db:"id" json:"id"`
+	TenantId  string    `db:"tenant_id" json:"-"`
+	UserId    int       `db:"user_id" json:"user_id"`
+	Action    string    `db:"action" json:"action"`
+	Actor     string    `db:"actor" json:"actor"`
+	RequestId string    `db:"request_id" json:"request_id,omitempty"`
+	Changes   Changes   `db:"changes" json:"changes"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Change holds the value of a field before and after a change, nil when the user did not exist.
+type Change struct {
+	Before *string `json:"before"`
+	After  *string `json:"after"`
+}
+
+// Changes is the field-level diff of an audit entry, stored as JSON.
+type Changes map[string]Change
+
+func (c Changes) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (c *Changes) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case []byte:
+		return json.Unmarshal(data, c)
+	case string:
+		return json.Unmarshal([]byte(data), c)
+	case nil:
+		*c = nil
+		return nil
+	default:
+		return fmt.Errorf("can't scan %T into changes", value)
+	}
+}
+
+type History struct {
+	Data   []AuditEntry `json:"data"`
+	Size   int          `json:"size"`
+	Offset int          `json:"offset"`
+}
+
+const (
+	BatchCreate string = "create"
+	BatchUpdate string = "update"
+	BatchDelete string = "delete"
+
+	BatchModeTransaction string = "transaction"
+	BatchModeBestEffort  string = "best_effort"
+)
+
+// BatchRequest is a list of operations applied in a single transaction, or one by one
+// in best_effort mode.
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchOperation struct {
+	Index int          `json:"-"`
+	Op    string       `json:"op"`
+	Id    int          `json:"id,omitempty"`
+	User  *UserRequest `json:"user,omitempty"`
+}
+
+func (o *BatchOperation) Validate() error {
+	switch o.Op {
+	case BatchCreate, BatchUpdate:
+		if o.Op == BatchUpdate && o.Id < 1 {
+			return errors.New("id is mandatory")
+		}
+		if o.User == nil {
+			return errors.New("user is mandatory")
+		}
+		return o.User.Validate()
+	case BatchDelete:
+		if o.Id < 1 {
+			return errors.New("id is mandatory")
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s is not a valid op, use create, update or delete", o.Op)
+	}
+}
+
+// BatchResult is the outcome of one operation, Status is the HTTP status it would have had
+// as a single request.
+type BatchResult struct {
+	Index  int      `json:"index"`
+	Op     string   `json:"op"`
+	Id     int64    `json:"id,omitempty"`
+	Status int      `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// TotalMode tells the listing whether and how to count the rows matching the filter.
+type TotalMode string
+
+const (
+	TotalNone     TotalMode = ""
+	TotalExact    TotalMode = "exact"
+	TotalEstimate TotalMode = "estimate"
+)
+
+// Filter holds the search criteria of a user listing. When Cursor is set the listing
+// uses keyset pagination and Offset is ignored.
+type Filter struct {
+	Name   string
+	Size   int
+	Offset int
+	Cursor *Cursor
+	Total  TotalMode
+	Fields Fields
+}
+
+// userFields is the allow-list of fields that can be requested, in the order they are selected.
+var userFields = []string{"id", "name", "address", "dob", "created_at", "updated_at"}
+
+// Fields is a sparse fieldset: the subset of user fields a client asked for.
+type Fields []string
+
+// ParseFields reads a comma separated list of fields, rejecting the ones not in the allow-list.
+func ParseFields(value string) (Fields, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	fields := make(Fields, 0)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if !contains(userFields, field) {
+			return nil, fmt.Errorf("%s is not a valid field, use %s", field, strings.Join(userFields, ","))
+		}
+		if !contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Project returns the user with only the given fields, ready to be rendered.
+func (u User) Project(fields Fields) map[string]interface{} {
+	projection := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			projection[field] = u.Id
+		case "name":
+			projection[field] = u.Name
+		case "address":
+			projection[field] = u.Address
+		case "dob":
+			projection[field] = u.Dob
+		case "created_at":
+			projection[field] = u.CreatedAt
+		case "updated_at":
+			projection[field] = u.UpdatedAt
+		}
+	}
+	return projection
+}
+
+// Project returns the listing with every user restricted to the given fields.
+func (l UserList) Project(fields Fields) interface{} {
+	data := make([]map[string]interface{}, 0, len(l.Data))
+	for _, user := range l.Data {
+		data = append(data, user.Project(fields))
+	}
+
+	return struct {
+		UserList
+		Data []map[string]interface{} `json:"data"`
+	}{UserList: l, Data: data}
 }
 
 type User struct {
 	Id        int       `db:"id" json:"id,omitempty"`
+	TenantId  string    `db:"tenant_id" json:"-"`
 	Address   string    `db:"address" json:"address,omitempty"`
 	Dob       time.Time `db:"dob" json:"dob,omitempty"`
-	Name      string    `db:"name" json:"name,omitempty" validate:"required,min=3"`
+	Name      string    `db:"name" json:"name,omitempty" validate:"required,min=3,max=256"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
 }
@@ -24,14 +266,48 @@ func (u *User) Validate() error {
 	return validator.New().Struct(u)
 }
 
+// UserRequest is the body of a create or an update, only the fields a client can set.
+type UserRequest struct {
+	Name    string    `json:"name" validate:"required,min=3,max=256"`
+	Address string    `json:"address"`
+	Dob     time.Time `json:"dob"`
+}
+
+func (r *UserRequest) Validate() error {
+	return validator.New().Struct(r)
+}
+
+func (r *UserRequest) User() *User {
+	return &User{Name: r.Name, Address: r.Address, Dob: r.Dob}
+}
+
+// PatchRequest is the body of a patch, nil fields are left as they are.
+type PatchRequest struct {
+	Name    *string    `json:"name"`
+	Address *string    `json:"address"`
+	Dob     *time.Time `json:"dob"`
+}
+
+// Apply sets the fields of the request in the user.
+func (r *PatchRequest) Apply(user *User) {
+	if r.Name != nil {
+		user.Name = *r.Name
+	}
+	if r.Address != nil {
+		user.Address = *r.Address
+	}
+	if r.Dob != nil {
+		user.Dob = *r.Dob
+	}
+}
 
 type Location struct {
 	Type     string   `json:"type"`
 	Query    []string `json:"query"`
 	Features []struct {
-		ID         string   `json:"id"`
-		Type       string   `json:"type"`
-		PlaceType  []string `json:"place_type"`
+		ID        string    `json:"id"`
+		Type      string    `json:"type"`
+		PlaceType []string  `json:"place_type"`
 		Text      string    `json:"text"`
 		PlaceName string    `json:"place_name"`
 		Bbox      []float64 `json:"bbox,omitempty"`
@@ -0,0 +1,85 @@
+package user
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFields(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		value       string
+		assertError func(*testing.T, error)
+		assertFunc  func(*testing.T, Fields)
+	}{
+		{
+			name:  "Success - empty value selects every field",
+			value: "",
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, fields Fields) {
+				assert.Nil(t, fields)
+			},
+		},
+		{
+			name:  "Success - known fields without duplicates",
+			value: "id, name,dob,name",
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, fields Fields) {
+				assert.Equal(t, fields, Fields{"id", "name", "dob"})
+			},
+		},
+		{
+			name:  "Error - unknown field",
+			value: "id,password",
+			assertError: func(t *testing.T, e error) {
+				assert.NotNil(t, e)
+			},
+			assertFunc: func(t *testing.T, fields Fields) {
+				assert.Nil(t, fields)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := ParseFields(tt.value)
+			tt.assertError(t, err)
+			tt.assertFunc(t, fields)
+		})
+	}
+}
+
+func TestUserList_Project(t *testing.T) {
+	list := UserList{Data: []User{{Id: 1, Name: "Jhon", Address: "5th avenue"}}, Size: 20}
+
+	js, err := json.Marshal(list.Project(Fields{"id", "name"}))
+
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"data":[{"id":1,"name":"Jhon"}],"size":20,"offset":0,"has_more":false}`, string(js))
+}
+
+func TestPatchRequest_Apply(t *testing.T) {
+	user := User{Id: 1, Name: "Jhon", Address: "5th avenue"}
+
+	var request PatchRequest
+	assert.Nil(t, json.Unmarshal([]byte(`{"address":"6th avenue"}`), &request))
+	request.Apply(&user)
+
+	assert.Equal(t, user, User{Id: 1, Name: "Jhon", Address: "6th avenue"})
+}
+
+func TestUser_ValidateName(t *testing.T) {
+	// the name column is a varchar(256), a longer name is rejected before reaching it
+	user := User{Name: strings.Repeat("a", 256)}
+	assert.Nil(t, user.Validate())
+
+	user.Name += "a"
+	assert.Contains(t, user.Validate().Error(), "'Name' failed on the 'max' tag")
+}
@@ -1,27 +1,60 @@
 package user
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"github.com/users-api/cmd/server"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
+	"time"
 )
 
 type IHandler interface {
 	Create(w http.ResponseWriter, r *http.Request)
 	Update(w http.ResponseWriter, r *http.Request)
+	Patch(w http.ResponseWriter, r *http.Request)
 	Get(w http.ResponseWriter, r *http.Request)
+	History(w http.ResponseWriter, r *http.Request)
 	Find(w http.ResponseWriter, r *http.Request)
+	Search(w http.ResponseWriter, r *http.Request)
 	Delete(w http.ResponseWriter, r *http.Request)
+	Batch(w http.ResponseWriter, r *http.Request)
+	Import(w http.ResponseWriter, r *http.Request)
+
+	Duplicates(w http.ResponseWriter, r *http.Request)
+	Merge(w http.ResponseWriter, r *http.Request)
 
 	GetLocation(w http.ResponseWriter, r *http.Request)
 }
 
+const (
+	ScopeRead     string = "users:read"
+	ScopeWrite    string = "users:write"
+	ScopeDelete   string = "users:delete"
+	ScopeLocation string = "users:location"
+)
+
 const (
 	ErrorCodeInvalidParams    string = "INVALID_PARAMS"
 	ErrorMessageSizeInvalid   string = "The size is invalid"
 	ErrorMessageOffsetInvalid string = "The offset is invalid"
+	ErrorMessageCursorInvalid string = "The cursor is invalid"
+	ErrorMessageTotalInvalid  string = "The include_total is invalid, use true, false or estimate"
+	ErrorMessageAsOfInvalid   string = "The as_of is invalid, use an RFC 3339 date time"
+
+	ErrorMessageBatchModeInvalid string = "The mode is invalid, use transaction or best_effort"
+	ErrorMessageBatchSizeInvalid string = "The batch must have between 1 and %d operations"
+	ErrorMessageBatchDeleteScope string = "the users:delete scope is required for delete operations"
+	defaultBatchMaxSize          int    = 1000
+	// MaxPageSize is the largest size of a page of a listing
+	MaxPageSize int = 100
+
+	ErrorMessageDryRunInvalid   string = "The dry_run is invalid, use true or false"
+	ErrorMessageImportMediaType string = "use text/csv or application/x-ndjson"
 )
 
 type Handler struct {
@@ -29,19 +62,19 @@ type Handler struct {
 }
 
 func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
-	var user *User
+	var request UserRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		server.BadRequest(w, r, ErrorCodeInvalidParams, err.Error())
+	if err := server.DecodeJSON(r, &request); err != nil {
+		server.BodyError(w, r, ErrorCodeInvalidParams, err)
 		return
 	}
 
-	if err := user.Validate(); err != nil {
+	if err := request.Validate(); err != nil {
 		server.BadRequest(w, r, ErrorCodeInvalidParams, err.Error())
 		return
 	}
 
-	id, err := h.service.Create(user)
+	id, err := h.service.Create(r.Context(), request.User())
 	if err != nil {
 		server.InternalServerError(w, r, err)
 		return
@@ -57,19 +90,19 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user *User
+	var request UserRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		server.BadRequest(w, r, ErrorCodeInvalidParams, err.Error())
+	if err := server.DecodeJSON(r, &request); err != nil {
+		server.BodyError(w, r, ErrorCodeInvalidParams, err)
 		return
 	}
 
-	if err := user.Validate(); err != nil {
+	if err := request.Validate(); err != nil {
 		server.BadRequest(w, r, ErrorCodeInvalidParams, err.Error())
 		return
 	}
 
-	if err := h.service.Update(userId, user); err != nil {
+	if err := h.service.Update(r.Context(), userId, request.User()); err != nil {
 		handlerException(w, r, err)
 		return
 	}
@@ -78,6 +111,38 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Patch changes only the fields present in the body.
+func (h *Handler) Patch(w http.ResponseWriter, r *http.Request) {
+	userId, err := server.GetIntFromPath(r, "id")
+	if err != nil {
+		server.BadRequest(w, r, "")
+		return
+	}
+
+	var request PatchRequest
+
+	if err := server.DecodeJSON(r, &request); err != nil {
+		server.BodyError(w, r, ErrorCodeInvalidParams, err)
+		return
+	}
+
+	user, err := h.service.Patch(r.Context(), userId, func(user *User) error {
+		request.Apply(user)
+
+		if err := user.Validate(); err != nil {
+			return &ValidationError{Message: err}
+		}
+		return nil
+	})
+
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	server.OK(w, r, user)
+}
+
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	userId, err := server.GetIntFromPath(r, "id")
 	if err != nil {
@@ -85,7 +150,7 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.Delete(userId); err != nil {
+	if err := h.service.Delete(r.Context(), userId); err != nil {
 		handlerException(w, r, err)
 		return
 	}
@@ -93,6 +158,52 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	server.OK(w, r, nil)
 }
 
+func (h *Handler) Batch(w http.ResponseWriter, r *http.Request) {
+	var request BatchRequest
+
+	if err := server.DecodeJSON(r, &request); err != nil {
+		server.BodyError(w, r, ErrorCodeInvalidParams, err)
+		return
+	}
+
+	if request.Mode != "" && request.Mode != BatchModeTransaction && request.Mode != BatchModeBestEffort {
+		server.BadRequest(w, r, ErrorCodeInvalidParams, ErrorMessageBatchModeInvalid)
+		return
+	}
+
+	maxSize := viper.GetInt("batch.max_size")
+	if maxSize < 1 {
+		maxSize = defaultBatchMaxSize
+	}
+
+	if len(request.Operations) == 0 || len(request.Operations) > maxSize {
+		server.BadRequest(w, r, ErrorCodeInvalidParams, fmt.Sprintf(ErrorMessageBatchSizeInvalid, maxSize))
+		return
+	}
+
+	if claims := server.GetClaims(r.Context()); claims != nil && !claims.HasScope(ScopeDelete) {
+		for _, operation := range request.Operations {
+			if operation.Op == BatchDelete {
+				server.Forbidden(w, r, server.ErrorCodeInsufficientScope, ErrorMessageBatchDeleteScope)
+				return
+			}
+		}
+	}
+
+	results, err := h.service.Batch(r.Context(), request)
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if failed(results) {
+		status = http.StatusMultiStatus
+	}
+
+	server.Render(w, r, BatchResponse{Results: results}, status)
+}
+
 func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 	userId, err := server.GetIntFromPath(r, "id")
 	if err != nil {
@@ -100,16 +211,78 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.service.Get(userId)
+	fields, err := ParseFields(server.GetStringParam(r, "fields", ""))
+	if err != nil {
+		server.BadRequest(w, r, ErrorCodeInvalidParams, err.Error())
+		return
+	}
+
+	var user User
+	if asOf := server.GetStringParam(r, "as_of", ""); asOf != "" {
+		at, err := time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			server.BadRequest(w, r, ErrorCodeInvalidParams, ErrorMessageAsOfInvalid)
+			return
+		}
+		user, err = h.service.GetAsOf(r.Context(), userId, at)
+	} else {
+		user, err = h.service.Get(r.Context(), userId, fields...)
+	}
 
 	if err != nil {
 		handlerException(w, r, err)
 		return
 	}
 
+	if len(fields) > 0 {
+		server.OK(w, r, user.Project(fields))
+		return
+	}
+
 	server.OK(w, r, user)
 }
 
+// History returns the audited changes of the user, newest first.
+func (h *Handler) History(w http.ResponseWriter, r *http.Request) {
+	userId, err := server.GetIntFromPath(r, "id")
+	if err != nil {
+		server.BadRequest(w, r, "")
+		return
+	}
+
+	size, offset, message := pageParams(r)
+	if message != "" {
+		server.BadRequest(w, r, ErrorCodeInvalidParams, message)
+		return
+	}
+
+	history, err := h.service.History(r.Context(), userId, size, offset)
+
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	server.OK(w, r, history)
+}
+
+// pageParams reads the size and offset of a listing, a size of 0 takes the default one. It
+// returns the message of an invalid one. The Validation middleware checks the same bounds from
+// the docs, but it is opt-in with validation.requests, so the handlers can't rely on it.
+func pageParams(r *http.Request) (int, int, string) {
+	size, err := server.GetIntParam(r, "size", 0)
+	if err != nil || size < 0 || size > MaxPageSize {
+		return 0, 0, ErrorMessageSizeInvalid
+	}
+
+	offset, err := server.GetIntParam(r, "offset", 0)
+	if err != nil || offset < 0 {
+		return 0, 0, ErrorMessageOffsetInvalid
+	}
+
+	return size, offset, ""
+}
+
 func (h *Handler) Find(w http.ResponseWriter, r *http.Request) {
 	name := server.GetStringParam(r, "name", "")
 	if name == "" {
@@ -117,29 +290,164 @@ func (h *Handler) Find(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	size, err := server.GetIntParam(r, "size", 0)
-	if err != nil {
-		server.BadRequest(w, r, ErrorCodeInvalidParams, ErrorMessageSizeInvalid)
+	size, offset, message := pageParams(r)
+	if message != "" {
+		server.BadRequest(w, r, ErrorCodeInvalidParams, message)
 		return
 	}
 
-	offset, err := server.GetIntParam(r, "offset", 0)
+	fields, err := ParseFields(server.GetStringParam(r, "fields", ""))
 	if err != nil {
-		server.BadRequest(w, r, ErrorCodeInvalidParams, ErrorMessageOffsetInvalid)
+		server.BadRequest(w, r, ErrorCodeInvalidParams, err.Error())
 		return
 	}
 
-	users, err := h.service.Find(name, size, offset)
+	filter := Filter{Name: name, Size: size, Offset: offset, Fields: fields}
+
+	if token := server.GetStringParam(r, "cursor", ""); token != "" {
+		if filter.Cursor, err = DecodeCursor(token); err != nil {
+			server.BadRequest(w, r, ErrorCodeInvalidParams, ErrorMessageCursorInvalid)
+			return
+		}
+	}
+
+	switch server.GetStringParam(r, "include_total", "false") {
+	case "false":
+	case "true", "exact":
+		filter.Total = TotalExact
+	case "estimate":
+		filter.Total = TotalEstimate
+	default:
+		server.BadRequest(w, r, ErrorCodeInvalidParams, ErrorMessageTotalInvalid)
+		return
+	}
+
+	users, err := h.service.Find(r.Context(), filter)
 
 	if err != nil {
 		handlerException(w, r, err)
 		return
 	}
 
+	addPageLinks(w, r, filter, users)
+
+	if len(fields) > 0 {
+		server.OK(w, r, users.Project(fields))
+		return
+	}
+
 	server.OK(w, r, users)
 
 }
 
+// Import streams the rows of a CSV or NDJSON body into the service, a file with rejected rows
+// still gets a 200 with the report.
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := strconv.ParseBool(server.GetStringParam(r, "dry_run", "false"))
+	if err != nil {
+		server.BadRequest(w, r, ErrorCodeInvalidParams, ErrorMessageDryRunInvalid)
+		return
+	}
+
+	var rows ImportReader
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		columns, err := ParseColumns(server.GetStringParam(r, "columns", ""))
+		if err != nil {
+			server.BadRequest(w, r, ErrorCodeInvalidParams, err.Error())
+			return
+		}
+		if rows, err = NewCSVReader(r.Body, columns); err != nil {
+			server.BodyError(w, r, ErrorCodeInvalidParams, err)
+			return
+		}
+	case "application/x-ndjson", "application/ndjson":
+		rows = NewNDJSONReader(r.Body)
+	default:
+		server.UnsupportedMediaType(w, r, fmt.Sprintf("%s is not supported, %s", contentType, ErrorMessageImportMediaType))
+		return
+	}
+
+	report, err := h.service.Import(r.Context(), rows, dryRun)
+	var readErr *ImportReadError
+	if errors.As(err, &readErr) {
+		server.BodyError(w, r, ErrorCodeInvalidParams, readErr.Err)
+		return
+	}
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	server.OK(w, r, report)
+}
+
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	query := server.GetStringParam(r, "q", "")
+	if query == "" {
+		server.BadRequest(w, r, ErrorCodeInvalidParams, "q is mandatory")
+		return
+	}
+
+	size, offset, message := pageParams(r)
+	if message != "" {
+		server.BadRequest(w, r, ErrorCodeInvalidParams, message)
+		return
+	}
+
+	results, err := h.service.Search(r.Context(), query, size, offset)
+
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	server.OK(w, r, results)
+}
+
+func (h *Handler) Duplicates(w http.ResponseWriter, r *http.Request) {
+	userId, err := server.GetIntFromPath(r, "id")
+	if err != nil {
+		server.BadRequest(w, r, "")
+		return
+	}
+
+	duplicates, err := h.service.Duplicates(r.Context(), userId)
+
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	server.OK(w, r, duplicates)
+}
+
+func (h *Handler) Merge(w http.ResponseWriter, r *http.Request) {
+	userId, err := server.GetIntFromPath(r, "id")
+	if err != nil {
+		server.BadRequest(w, r, "")
+		return
+	}
+
+	var request MergeRequest
+
+	if err := server.DecodeJSON(r, &request); err != nil {
+		server.BodyError(w, r, ErrorCodeInvalidParams, err)
+		return
+	}
+
+	user, err := h.service.Merge(r.Context(), userId, request)
+
+	if err != nil {
+		handlerException(w, r, err)
+		return
+	}
+
+	server.OK(w, r, user)
+}
+
 func (h *Handler) GetLocation(w http.ResponseWriter, r *http.Request) {
 	userId, err := server.GetIntFromPath(r, "id")
 	if err != nil {
@@ -147,7 +455,7 @@ func (h *Handler) GetLocation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	location, err := h.service.GetLocation(userId)
+	location, err := h.service.GetLocation(r.Context(), userId)
 
 	if err != nil {
 		handlerException(w, r, err)
@@ -157,31 +465,86 @@ func (h *Handler) GetLocation(w http.ResponseWriter, r *http.Request) {
 	server.OK(w, r, location)
 }
 
+func addPageLinks(w http.ResponseWriter, r *http.Request, filter Filter, users UserList) {
+	size := strconv.Itoa(users.Size)
+	server.AddLink(w, r, "first", map[string]string{"size": size, "offset": "", "cursor": ""})
+
+	if filter.Cursor != nil {
+		if users.PrevCursor != "" {
+			server.AddLink(w, r, "prev", map[string]string{"size": size, "cursor": users.PrevCursor})
+		}
+		if users.NextCursor != "" {
+			server.AddLink(w, r, "next", map[string]string{"size": size, "cursor": users.NextCursor})
+		}
+	} else {
+		if filter.Offset > 0 {
+			prev := filter.Offset - users.Size
+			if prev < 0 {
+				prev = 0
+			}
+			server.AddLink(w, r, "prev", map[string]string{"size": size, "offset": strconv.Itoa(prev)})
+		}
+		if users.HasMore {
+			server.AddLink(w, r, "next", map[string]string{"size": size, "offset": strconv.Itoa(filter.Offset + users.Size)})
+		}
+	}
+
+	if users.TotalCount != nil && !users.TotalEstimated && filter.Cursor == nil {
+		last := 0
+		if *users.TotalCount > 0 {
+			last = int((*users.TotalCount - 1) / int64(users.Size) * int64(users.Size))
+		}
+		server.AddLink(w, r, "last", map[string]string{"size": size, "offset": strconv.Itoa(last)})
+		return
+	}
+
+	server.AddLink(w, r, "last", map[string]string{"size": size, "offset": "", "cursor": LastPageCursor().Encode()})
+}
+
 func handlerException(w http.ResponseWriter, r *http.Request, err error) {
 	switch err.(type) {
 	case *NotFoundError:
 		server.NotFound(w, r, err.Error())
+	case *ValidationError:
+		server.BadRequest(w, r, ErrorCodeInvalidParams, err.Error())
+	case *MergedError:
+		survivor := path.Join(path.Dir(r.URL.Path), strconv.Itoa(err.(*MergedError).SurvivorId))
+		server.MovedPermanently(w, r, survivor, err.Error())
 	default:
 		logrus.Error(err)
 		server.InternalServerError(w, r, err)
 	}
 }
 
-func RegisterRoutes(s *server.Server) {
-
-	handler := newHandler()
-
-	s.AddRoute("/v{version}/users", handler.Create, http.MethodPost)
-	s.AddRoute("/v{version}/users/{id}", handler.Update, http.MethodPut)
-	s.AddRoute("/v{version}/users/{id}", handler.Get, http.MethodGet)
-	s.AddRoute("/v{version}/users", handler.Find, http.MethodGet)
-	s.AddRoute("/v{version}/users/{id}", handler.Delete, http.MethodDelete)
+func RegisterRoutes(s *server.Server, service IService) {
+	registerV1(s.APIVersion("1"), newHandler(service))
+}
 
-	s.AddRoute("/v{version}/users/{id}/locations", handler.GetLocation, http.MethodGet)
+// registerV1 adds the routes of version 1. A new version registers its own handlers and
+// requests rather than changing the ones of a published version.
+func registerV1(v *server.APIVersion, handler IHandler) {
+	v.AddRoute("/users", handler.Create, http.MethodPost).Name("users.create").Scopes(ScopeWrite).Doc(createDoc)
+	// deletes in a batch also need users:delete, checked by the handler
+	v.AddRoute("/users:batch", handler.Batch, http.MethodPost).Name("users.batch").Scopes(ScopeWrite).Doc(batchDoc)
+	v.AddRoute("/users/import", handler.Import, http.MethodPost).Name("users.import").Scopes(ScopeWrite).Doc(importDoc)
+	// registered before /users/{id} so that "search" is not read as an id
+	v.AddRoute("/users/search", handler.Search, http.MethodGet).Name("users.search").Scopes(ScopeRead).Doc(searchDoc)
+	v.AddRoute("/users/{id}", handler.Update, http.MethodPut).Name("users.update").Scopes(ScopeWrite).Doc(updateDoc)
+	v.AddRoute("/users/{id}", handler.Patch, http.MethodPatch).Name("users.patch").Scopes(ScopeWrite).Doc(patchDoc)
+	v.AddRoute("/users/{id}", handler.Get, http.MethodGet).Name("users.get").Scopes(ScopeRead).Doc(getDoc)
+	v.AddRoute("/users", handler.Find, http.MethodGet).Name("users.find").Scopes(ScopeRead).Doc(findDoc)
+	v.AddRoute("/users/{id}", handler.Delete, http.MethodDelete).Name("users.delete").Scopes(ScopeDelete).Doc(deleteDoc)
+
+	v.AddRoute("/users/{id}/history", handler.History, http.MethodGet).Name("users.history").Scopes(ScopeRead).Doc(historyDoc)
+
+	v.AddRoute("/users/{id}/duplicates", handler.Duplicates, http.MethodGet).Name("users.duplicates").Scopes(ScopeRead).Doc(duplicatesDoc)
+	v.AddRoute("/users/{id}/merge", handler.Merge, http.MethodPost).Name("users.merge").Scopes(ScopeWrite, ScopeDelete).Doc(mergeDoc)
+
+	v.AddRoute("/users/{id}/locations", handler.GetLocation, http.MethodGet).Name("users.location").Scopes(ScopeLocation).Doc(locationDoc)
 }
 
-func newHandler() IHandler {
+func newHandler(service IService) IHandler {
 	return &Handler{
-		service: NewService(),
+		service: service,
 	}
 }
@@ -0,0 +1,145 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/users-api/cmd/server"
+)
+
+func TestHandlerPageParams(t *testing.T) {
+	// the repository mock has no expectations: an invalid page must not reach it
+	h := &Handler{service: NewServiceWithRepository(&RepositoryMock{})}
+
+	handlers := []struct {
+		name    string
+		path    string
+		handler http.HandlerFunc
+	}{
+		{name: "find", path: "/users?name=jhon&", handler: h.Find},
+		{name: "search", path: "/users/search?q=jhon&", handler: h.Search},
+		{name: "history", path: "/users/1/history?", handler: h.History},
+	}
+
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{query: "size=-1", message: ErrorMessageSizeInvalid},
+		{query: "size=-5", message: ErrorMessageSizeInvalid},
+		{query: "size=101", message: ErrorMessageSizeInvalid},
+		{query: "size=100000000", message: ErrorMessageSizeInvalid},
+		{query: "size=ten", message: ErrorMessageSizeInvalid},
+		{query: "offset=-1", message: ErrorMessageOffsetInvalid},
+		{query: "size=10&offset=-20", message: ErrorMessageOffsetInvalid},
+	}
+
+	for _, hh := range handlers {
+		for _, tt := range tests {
+			t.Run(hh.name+" "+tt.query, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, hh.path+tt.query, nil)
+				r = mux.SetURLVars(r, map[string]string{"id": "1"})
+				w := httptest.NewRecorder()
+
+				hh.handler(w, r)
+
+				var body struct {
+					Code     string   `json:"code"`
+					Messages []string `json:"messages"`
+				}
+				assert.Equal(t, w.Code, http.StatusBadRequest)
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, body.Code, ErrorCodeInvalidParams)
+				assert.Equal(t, body.Messages, []string{tt.message})
+			})
+		}
+	}
+}
+
+func TestPageParams(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/users?size=100&offset=0", nil)
+	size, offset, message := pageParams(r)
+	assert.Equal(t, size, MaxPageSize)
+	assert.Equal(t, offset, 0)
+	assert.Equal(t, message, "")
+
+	size, offset, message = pageParams(httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Equal(t, size, 0)
+	assert.Equal(t, offset, 0)
+	assert.Equal(t, message, "")
+}
+
+// importServiceMock mocks the Import of the service, the other methods are not expected.
+type importServiceMock struct {
+	IService
+	mock.Mock
+}
+
+func (m *importServiceMock) Import(ctx context.Context, rows ImportReader, dryRun bool) (ImportReport, error) {
+	args := m.Called(dryRun)
+	return args.Get(0).(ImportReport), args.Error(1)
+}
+
+func TestHandlerImportErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{
+			name:   "file cut short",
+			err:    &ImportReadError{Err: errors.New("unexpected EOF")},
+			status: http.StatusBadRequest,
+			code:   ErrorCodeInvalidParams,
+		},
+		{
+			name:   "file over the limit",
+			err:    &ImportReadError{Err: &server.BodyTooLargeError{Limit: 10}},
+			status: http.StatusRequestEntityTooLarge,
+			code:   server.ErrorCodeRequestTooLarge,
+		},
+		{
+			name:   "database failure",
+			err:    errors.New("driver: bad connection"),
+			status: http.StatusInternalServerError,
+			code:   "INTERNAL_SERVER_ERROR",
+		},
+		{
+			name:   "timeout",
+			err:    context.DeadlineExceeded,
+			status: http.StatusInternalServerError,
+			code:   "INTERNAL_SERVER_ERROR",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &importServiceMock{}
+			service.On("Import", false).Return(ImportReport{}, tt.err).Once()
+			h := &Handler{service: service}
+
+			r := httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader("{\"name\":\"Jhon\"}\n"))
+			r.Header.Set("Content-Type", "application/x-ndjson")
+			w := httptest.NewRecorder()
+
+			h.Import(w, r)
+
+			var body struct {
+				Code string `json:"code"`
+			}
+			service.AssertExpectations(t)
+			assert.Equal(t, w.Code, tt.status)
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, body.Code, tt.code)
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package user
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ImportFormatCSV    string = "csv"
+	ImportFormatNDJSON string = "ndjson"
+
+	defaultImportMaxErrors int = 1000
+)
+
+// importFields are the fields of a user an import file can set.
+var importFields = []string{"name", "address", "dob"}
+
+// ImportRow is a user read from a line of an import file, Errors tells why the line could not
+// be read into a user.
+type ImportRow struct {
+	Line   int
+	User   *UserRequest
+	Errors []string
+}
+
+// ImportReader reads the rows of an import file one at a time. Read returns io.EOF after the
+// last row, any other error means the file itself can't be read any further.
+type ImportReader interface {
+	Read() (ImportRow, error)
+}
+
+// ImportReport is the outcome of an import. Errors lists the rejected rows, up to
+// import.max_errors of them.
+type ImportReport struct {
+	DryRun          bool          `json:"dry_run"`
+	Rows            int           `json:"rows"`
+	Accepted        int           `json:"accepted"`
+	Rejected        int           `json:"rejected"`
+	Errors          []ImportError `json:"errors"`
+	ErrorsTruncated bool          `json:"errors_truncated,omitempty"`
+}
+
+// ImportReadError is an error reading the file of an import, e.g. a body over its limit or cut
+// short, as opposed to the errors of the database.
+type ImportReadError struct {
+	Err error
+}
+
+func (e *ImportReadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ImportReadError) Unwrap() error {
+	return e.Err
+}
+
+type ImportError struct {
+	Line   int      `json:"line"`
+	Errors []string `json:"errors"`
+}
+
+// ParseColumns parses a header mapping of comma separated column:field pairs, e.g.
+// "full_name:name,birth_date:dob", into the field of each column.
+func ParseColumns(value string) (map[string]string, error) {
+	columns := make(map[string]string)
+	if value == "" {
+		return columns, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%s is not a column:field pair", pair)
+		}
+		field := strings.ToLower(strings.TrimSpace(parts[1]))
+		if !contains(importFields, field) {
+			return nil, fmt.Errorf("%s is not a field, use %s", parts[1], strings.Join(importFields, ", "))
+		}
+		columns[strings.ToLower(strings.TrimSpace(parts[0]))] = field
+	}
+	return columns, nil
+}
+
+// CSVReader reads the users of a CSV file with a header. The columns are mapped to the fields
+// by the header mapping, a column it doesn't map is read into the field of its own name and
+// ignored when there isn't one.
+type CSVReader struct {
+	lines  *lineReader
+	csv    *csv.Reader
+	fields []string
+}
+
+// NewCSVReader reads the header of the file, which must have a name column once mapped.
+func NewCSVReader(r io.Reader, columns map[string]string) (*CSVReader, error) {
+	lines := &lineReader{r: bufio.NewReader(r), start: true}
+	reader := &CSVReader{lines: lines, csv: csv.NewReader(lines)}
+	reader.csv.FieldsPerRecord = -1
+
+	header, err := reader.csv.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty, it must start with a header")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := make(map[string]bool)
+	found := make(map[string]bool)
+	for i, column := range header {
+		if i == 0 {
+			// files saved by spreadsheets often start with a byte order mark
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+		column = strings.ToLower(strings.TrimSpace(column))
+
+		field, ok := columns[column]
+		if !ok && contains(importFields, column) {
+			field = column
+		}
+		if field != "" && mapped[field] {
+			return nil, fmt.Errorf("the header has more than one %s column", field)
+		}
+		mapped[field] = true
+		reader.fields = append(reader.fields, field)
+		found[column] = true
+	}
+
+	missing := make([]string, 0)
+	for column := range columns {
+		if !found[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("the header has no %s column", strings.Join(missing, ", "))
+	}
+	if !mapped["name"] {
+		return nil, errors.New("the header has no name column")
+	}
+	return reader, nil
+}
+
+func (c *CSVReader) Read() (ImportRow, error) {
+	record, err := c.csv.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && parseErr.Err != nil {
+			return ImportRow{Line: parseErr.StartLine, Errors: []string{parseErr.Err.Error()}}, nil
+		}
+		return ImportRow{}, err
+	}
+
+	// the csv reader read up to the last line of the record, which can span several lines
+	row := ImportRow{Line: c.lines.lines}
+	for _, value := range record {
+		row.Line -= strings.Count(value, "\n")
+	}
+
+	if len(record) != len(c.fields) {
+		row.Errors = []string{fmt.Sprintf("the row has %d columns, the header has %d", len(record), len(c.fields))}
+		return row, nil
+	}
+
+	values := make(map[string]string)
+	for i, field := range c.fields {
+		if field != "" {
+			values[field] = strings.TrimSpace(record[i])
+		}
+	}
+	row.User, row.Errors = importUser(values["name"], values["address"], values["dob"])
+	return row, nil
+}
+
+// lineReader hands the csv reader one line per Read, so the lines it has handed are the ones of
+// the records the csv reader returned: encoding/csv doesn't tell the line of a record.
+type lineReader struct {
+	r       *bufio.Reader
+	pending []byte
+	err     error
+	// lines counts the lines started, start tells whether the next byte starts one
+	lines int
+	start bool
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	if len(l.pending) == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+
+		line, err := l.r.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			l.err = err
+		}
+		if len(line) == 0 {
+			return 0, l.err
+		}
+		if l.start {
+			l.lines++
+		}
+		l.start = line[len(line)-1] == '\n'
+		l.pending = line
+	}
+
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+// NDJSONReader reads the users of a file with a JSON object per line, blank lines are skipped
+// and so are the fields other than name, address and dob, e.g. the ones of an export.
+type NDJSONReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func NewNDJSONReader(r io.Reader) *NDJSONReader {
+	return &NDJSONReader{r: bufio.NewReader(r)}
+}
+
+// importRecord is a line of an NDJSON file, the dob is read like the one of a CSV file.
+type importRecord struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Dob     string `json:"dob"`
+}
+
+func (n *NDJSONReader) Read() (ImportRow, error) {
+	for {
+		line, err := n.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return ImportRow{}, err
+		}
+		n.line++
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		row := ImportRow{Line: n.line}
+
+		var record importRecord
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		if err := decoder.Decode(&record); err != nil {
+			row.Errors = []string{err.Error()}
+			return row, nil
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			row.Errors = []string{"the line must hold a single JSON object"}
+			return row, nil
+		}
+
+		row.User, row.Errors = importUser(record.Name, record.Address, record.Dob)
+		return row, nil
+	}
+}
+
+// importUser builds the user of a row, the dob is a date, e.g. 1990-12-31, or an RFC 3339 date time.
+func importUser(name string, address string, dob string) (*UserRequest, []string) {
+	user := &UserRequest{Name: name, Address: address}
+	if dob == "" {
+		return user, nil
+	}
+
+	var err error
+	if user.Dob, err = time.Parse("2006-01-02", dob); err != nil {
+		if user.Dob, err = time.Parse(time.RFC3339, dob); err != nil {
+			return nil, []string{fmt.Sprintf("the dob %s is invalid, use a date like 1990-12-31 or an RFC 3339 date time", dob)}
+		}
+	}
+	return user, nil
+}
+
+func (r *ImportReport) reject(line int, errors []string, maxErrors int) {
+	r.Rejected++
+	if len(r.Errors) >= maxErrors {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, ImportError{Line: line, Errors: errors})
+}
@@ -0,0 +1,129 @@
+package user
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readRows(t *testing.T, rows ImportReader) []ImportRow {
+	var read []ImportRow
+	for {
+		row, err := rows.Read()
+		if err == io.EOF {
+			return read
+		}
+		assert.Nil(t, err)
+		if err != nil {
+			return read
+		}
+		read = append(read, row)
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns(" Full Name:name, birth:DOB")
+	assert.Nil(t, err)
+	assert.Equal(t, columns, map[string]string{"full name": "name", "birth": "dob"})
+
+	_, err = ParseColumns("full_name")
+	assert.EqualError(t, err, "full_name is not a column:field pair")
+	_, err = ParseColumns("email:email")
+	assert.EqualError(t, err, "email is not a field, use name, address, dob")
+}
+
+func TestImportCSV(t *testing.T) {
+	dob := time.Date(1990, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		file    string
+		columns map[string]string
+		err     string
+		rows    []ImportRow
+	}{
+		{
+			name: "columns named like the fields",
+			file: "\ufeffName,Address,DOB\nJhon,5th avenue,1990-12-31\n\nJane,,\n",
+			rows: []ImportRow{
+				{Line: 2, User: &UserRequest{Name: "Jhon", Address: "5th avenue", Dob: dob}},
+				{Line: 4, User: &UserRequest{Name: "Jane"}},
+			},
+		},
+		{
+			name:    "mapped columns and ignored ones",
+			file:    "id,full_name,birth\n1,Jhon,1990-12-31T00:00:00Z\n2,Jane,31/12/1990",
+			columns: map[string]string{"full_name": "name", "birth": "dob"},
+			rows: []ImportRow{
+				{Line: 2, User: &UserRequest{Name: "Jhon", Dob: dob}},
+				{Line: 3, Errors: []string{"the dob 31/12/1990 is invalid, use a date like 1990-12-31 or an RFC 3339 date time"}},
+			},
+		},
+		{
+			name: "lines of records spanning several lines",
+			file: "name,address\r\nJhon,\"5th avenue\r\nNew York\"\r\nJane,\"Main \"street\"\nJo,Main street,1\nJim,Main street\n",
+			rows: []ImportRow{
+				{Line: 2, User: &UserRequest{Name: "Jhon", Address: "5th avenue\nNew York"}},
+				{Line: 4, Errors: []string{`extraneous or missing " in quoted-field`}},
+				{Line: 5, Errors: []string{"the row has 3 columns, the header has 2"}},
+				{Line: 6, User: &UserRequest{Name: "Jim", Address: "Main street"}},
+			},
+		},
+		{
+			name: "empty file",
+			file: "",
+			err:  "the file is empty, it must start with a header",
+		},
+		{
+			name: "no name column",
+			file: "full_name,address\n",
+			err:  "the header has no name column",
+		},
+		{
+			name:    "mapped column not in the header",
+			file:    "name\n",
+			columns: map[string]string{"birth": "dob"},
+			err:     "the header has no birth column",
+		},
+		{
+			name:    "two name columns",
+			file:    "name,full_name\n",
+			columns: map[string]string{"full_name": "name"},
+			err:     "the header has more than one name column",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := NewCSVReader(strings.NewReader(tt.file), tt.columns)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, readRows(t, rows), tt.rows)
+		})
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	file := `{"name":"Jhon","address":"5th avenue","dob":"1990-12-31"}
+
+{"id":3,"name":"Jane","created_at":"2020-01-01T00:00:00Z"}
+{"name":"Jo"
+{"name":"Jim"} {"name":"Joe"}
+{"name":"Ann","dob":"yesterday"}`
+
+	rows := readRows(t, NewNDJSONReader(strings.NewReader(file)))
+
+	assert.Equal(t, rows, []ImportRow{
+		{Line: 1, User: &UserRequest{Name: "Jhon", Address: "5th avenue", Dob: time.Date(1990, 12, 31, 0, 0, 0, 0, time.UTC)}},
+		{Line: 3, User: &UserRequest{Name: "Jane"}},
+		{Line: 4, Errors: []string{"unexpected EOF"}},
+		{Line: 5, Errors: []string{"the line must hold a single JSON object"}},
+		{Line: 6, Errors: []string{"the dob yesterday is invalid, use a date like 1990-12-31 or an RFC 3339 date time"}},
+	})
+}
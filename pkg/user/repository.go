@@ -1,6 +1,7 @@
 package user
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -10,24 +11,58 @@ import (
 	"github.com/spf13/viper"
 	"github.com/users-api/infrastructure"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type IRepository interface {
 	Insert(user *User) (int64, error)
 	Update(userId int, user *User) error
-	Get(userId int) (User, error)
-	Find(name string, size int, offset int) (UserList, error)
+	Get(userId int, fields ...string) (User, error)
+	GetForUpdate(userId int) (User, error)
+	Find(filter Filter) (UserList, error)
+	Search(query string, size int, offset int) (SearchList, error)
+	InsertMany(users []*User) ([]int64, error)
 	Delete(userId int) error
+	WithTx(ctx context.Context, fn func(repo IRepository) error) error
+	ForTenant(tenant string) IRepository
+
+	InsertAudit(entry *AuditEntry) error
+	History(userId int, size int, offset int) (History, error)
+	AuditSince(userId int, since time.Time) ([]AuditEntry, error)
+
+	FindCandidates(user User, limit int) ([]User, error)
+	Merge(survivorId int, mergedIds []int) error
+	GetSurvivor(userId int) (int, error)
 
 	GetLocation(userId int) (Location, error)
 }
 
+// executor runs statements either directly on the database or inside a transaction.
+type executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	NamedExec(query string, arg interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
+	DriverName() string
+}
+
+// Repository reads and writes the users of a single tenant, every query is filtered by it.
+// A repository without tenant, like the one NewRepository returns, fails every query with
+// ErrNoTenant until ForTenant binds it to one.
 type Repository struct {
 	db           *sqlx.DB
+	tx           *sqlx.Tx
+	depth        int
+	tenant       string
 	mapApiClient infrastructure.RestClient
 }
 
+// ErrNoTenant is returned by the queries of a repository that is not bound to a tenant.
+var ErrNoTenant = errors.New("the repository is not bound to a tenant")
+
 type NotFoundError struct {
 	Message error
 }
@@ -36,21 +71,60 @@ func (e *NotFoundError) Error() string {
 	return e.Message.Error()
 }
 
+// MergedError is returned for users that were merged into another one.
+type MergedError struct {
+	UserId     int
+	SurvivorId int
+}
+
+func (e *MergedError) Error() string {
+	return fmt.Sprintf("user with id=%d was merged into user with id=%d", e.UserId, e.SurvivorId)
+}
+
+type ValidationError struct {
+	Message error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message.Error()
+}
+
 const (
-	insertUserSQL     string = "INSERT INTO user (name, address, dob) VALUES (:name,:address,:dob)"
-	getUserDataSQL    string = "SELECT id, name, address, dob, created_at, updated_at FROM user WHERE id = ?"
-	updateUserDataSQL string = "UPDATE user SET name=:name, address=:address, dob=:dob WHERE id =:id"
-	findUserDataSQL   string = "SELECT id, name, address, dob, created_at, updated_at FROM user WHERE name = ? Limit ?  Offset ?"
-	deleteUserSQL     string = "DELETE FROM user where id = ?"
-
-	locationUrl       string = "/geocoding/v5/mapbox.places/%s.json?access_token=%s"
-	userNotFound string = "user with id=%d not found"
-	defaultSize  int    = 20
+	insertUserSQL     string = "INSERT INTO user (tenant_id, name, address, dob) VALUES (:tenant_id,:name,:address,:dob)"
+	insertUsersSQL    string = "INSERT INTO user (tenant_id, name, address, dob) VALUES "
+	getUserDataSQL    string = "SELECT %s FROM user WHERE tenant_id = ? AND id = ?"
+	updateUserDataSQL string = "UPDATE user SET name=:name, address=:address, dob=:dob WHERE tenant_id = :tenant_id AND id =:id"
+	findUserDataSQL   string = "SELECT %s FROM user WHERE tenant_id = ? AND name = ? ORDER BY created_at, id LIMIT ? OFFSET ?"
+	findUserAfterSQL  string = "SELECT %s FROM user WHERE tenant_id = ? AND name = ? AND (created_at > ? OR (created_at = ? AND id > ?)) ORDER BY created_at, id LIMIT ?"
+	findUserBeforeSQL string = "SELECT %s FROM user WHERE tenant_id = ? AND name = ? AND (created_at < ? OR (created_at = ? AND id < ?)) ORDER BY created_at DESC, id DESC LIMIT ?"
+	findUserLastSQL   string = "SELECT %s FROM user WHERE tenant_id = ? AND name = ? ORDER BY created_at DESC, id DESC LIMIT ?"
+	countUserSQL      string = "SELECT COUNT(*) FROM user WHERE tenant_id = ? AND name = ?"
+	estimateUserSQL   string = "EXPLAIN SELECT id FROM user WHERE tenant_id = ? AND name = ?"
+	deleteUserSQL     string = "DELETE FROM user where tenant_id = ? AND id = ?"
+	lockUserSQL       string = "SELECT id, name, address, dob, created_at, updated_at FROM user WHERE tenant_id = ? AND id = ? FOR UPDATE"
+	insertAuditSQL    string = "INSERT INTO user_audit (tenant_id, user_id, action, actor, request_id, changes) VALUES (:tenant_id, :user_id, :action, :actor, :request_id, :changes)"
+	historySQL        string = "SELECT id, user_id, action, actor, request_id, changes, created_at FROM user_audit WHERE tenant_id = ? AND user_id = ? ORDER BY id DESC LIMIT ? OFFSET ?"
+	auditSinceSQL     string = "SELECT id, user_id, action, actor, request_id, changes, created_at FROM user_audit WHERE tenant_id = ? AND user_id = ? AND created_at > ? ORDER BY id DESC"
+	findCandidatesSQL string = "SELECT id, name, address, dob, created_at, updated_at FROM user WHERE tenant_id = ? AND id <> ? AND (dob = ? OR LOWER(name) LIKE ? ESCAPE '\\\\' OR LOWER(address) = LOWER(?)) ORDER BY id LIMIT ?"
+	repointMergeSQL   string = "UPDATE user_merge SET survivor_id = ? WHERE tenant_id = ? AND survivor_id IN (?)"
+	insertMergeSQL    string = "INSERT INTO user_merge (tenant_id, merged_id, survivor_id) VALUES (?, ?, ?)"
+	deleteMergedSQL   string = "DELETE FROM user WHERE tenant_id = ? AND id IN (?)"
+	getSurvivorSQL    string = "SELECT survivor_id FROM user_merge WHERE tenant_id = ? AND merged_id = ?"
+	searchUserSQL     string = "SELECT id, name, address, dob, created_at, updated_at, MATCH(name, address) AGAINST (? IN BOOLEAN MODE) AS score FROM user WHERE tenant_id = ? AND MATCH(name, address) AGAINST (? IN BOOLEAN MODE) ORDER BY score DESC, id LIMIT ? OFFSET ?"
+	searchUserLikeSQL string = "SELECT * FROM (SELECT id, name, address, dob, created_at, updated_at, %s AS score FROM user WHERE tenant_id = ?) matches WHERE score > 0 ORDER BY score DESC, id LIMIT ? OFFSET ?"
+
+	locationUrl    string = "/geocoding/v5/mapbox.places/%s.json?access_token=%s"
+	userNotFound   string = "user with id=%d not found"
+	userNotFoundAt string = "user with id=%d not found at %s"
+	defaultSize    int    = 20
 )
 
-func (r *Repository) Insert(user *User) (int64, error) {
+// cursorColumns are the sort keys listings need to build their pagination cursors.
+var cursorColumns = []string{"id", "created_at"}
 
-	result, err := r.db.NamedExec(insertUserSQL, user)
+func (r *Repository) Insert(user *User) (int64, error) {
+	user.TenantId = r.tenant
+	result, err := r.executor().NamedExec(insertUserSQL, user)
 	if err != nil {
 		return 0, errors.New("error while creating service")
 	}
@@ -63,16 +137,45 @@ func (r *Repository) Insert(user *User) (int64, error) {
 	return id, nil
 }
 
+// InsertMany creates the users with a single multi-row insert. InnoDB allocates consecutive
+// ids to the rows of a simple insert, so the ids are derived from the first one.
+func (r *Repository) InsertMany(users []*User) ([]int64, error) {
+	values := make([]string, 0, len(users))
+	args := make([]interface{}, 0, len(users)*4)
+	for _, user := range users {
+		values = append(values, "(?,?,?,?)")
+		args = append(args, r.tenant, user.Name, user.Address, user.Dob)
+	}
+
+	result, err := r.executor().Exec(insertUsersSQL+strings.Join(values, ","), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(users))
+	for i := range users {
+		ids = append(ids, first+int64(i))
+	}
+
+	return ids, nil
+}
+
 func (r *Repository) Update(userId int, user *User) error {
 	user.Id = userId
-	_, err := r.db.NamedExec(updateUserDataSQL, user)
+	user.TenantId = r.tenant
+	_, err := r.executor().NamedExec(updateUserDataSQL, user)
 
 	return err
 }
 
-func (r *Repository) Get(userId int) (User, error) {
+func (r *Repository) Get(userId int, fields ...string) (User, error) {
 	user := User{}
-	err := r.db.Get(&user, getUserDataSQL, userId)
+	err := r.executor().Get(&user, fmt.Sprintf(getUserDataSQL, selectColumns(fields)), r.tenant, userId)
 
 	if err == sql.ErrNoRows {
 		return user, &NotFoundError{Message: fmt.Errorf(userNotFound, userId)}
@@ -81,24 +184,226 @@ func (r *Repository) Get(userId int) (User, error) {
 	return user, err
 }
 
-func (r *Repository) Find(name string, size int, offset int) (UserList, error) {
-	if size == 0 {
+// GetForUpdate reads the user locking its row until the end of the transaction.
+func (r *Repository) GetForUpdate(userId int) (User, error) {
+	user := User{}
+	err := r.executor().Get(&user, lockUserSQL, r.tenant, userId)
+
+	if err == sql.ErrNoRows {
+		return user, &NotFoundError{Message: fmt.Errorf(userNotFound, userId)}
+	}
+
+	return user, err
+}
+
+func (r *Repository) Find(filter Filter) (UserList, error) {
+	size := filter.Size
+	if size <= 0 {
 		size = defaultSize
 	}
 
+	var list UserList
+	var err error
+	if filter.Cursor != nil {
+		list, err = r.findByCursor(filter, size)
+	} else {
+		list, err = r.findByOffset(filter, size)
+	}
+	if err != nil {
+		return UserList{}, err
+	}
+
+	if filter.Total != TotalNone {
+		total, estimated, err := r.count(filter.Name, filter.Total)
+		if err != nil {
+			return UserList{}, err
+		}
+		list.TotalCount = &total
+		list.TotalEstimated = estimated
+	}
+
+	return list, nil
+}
+
+func (r *Repository) findByOffset(filter Filter, size int) (UserList, error) {
 	users := make([]User, 0)
+	offset := filter.Offset
 
-	err := r.db.Select(&users, findUserDataSQL, name, size, offset)
+	// one extra row is read to know whether there is a next page
+	query := fmt.Sprintf(findUserDataSQL, selectColumns(filter.Fields, cursorColumns...))
+	err := r.executor().Select(&users, query, r.tenant, filter.Name, size+1, offset)
 	if err != nil {
 		return UserList{}, err
 	}
 
-	return UserList{Data: users, Size: size, Offset: offset}, nil
+	list := UserList{Size: size, Offset: offset, HasMore: len(users) > size}
+	if list.HasMore {
+		users = users[:size]
+		list.NextCursor = newCursor(users[size-1], false).Encode()
+	}
+	if offset > 0 && len(users) > 0 {
+		list.PrevCursor = newCursor(users[0], true).Encode()
+	}
+	list.Data = users
+
+	return list, nil
+}
+
+func (r *Repository) findByCursor(filter Filter, size int) (UserList, error) {
+	users := make([]User, 0)
+	cursor := filter.Cursor
+	columns := selectColumns(filter.Fields, cursorColumns...)
+
+	var err error
+	switch {
+	case cursor.atEnd():
+		err = r.executor().Select(&users, fmt.Sprintf(findUserLastSQL, columns), r.tenant, filter.Name, size+1)
+	case cursor.Backward:
+		err = r.executor().Select(&users, fmt.Sprintf(findUserBeforeSQL, columns), r.tenant, filter.Name, cursor.CreatedAt, cursor.CreatedAt, cursor.Id, size+1)
+	default:
+		err = r.executor().Select(&users, fmt.Sprintf(findUserAfterSQL, columns), r.tenant, filter.Name, cursor.CreatedAt, cursor.CreatedAt, cursor.Id, size+1)
+	}
+	if err != nil {
+		return UserList{}, err
+	}
+
+	more := len(users) > size
+	if more {
+		users = users[:size]
+	}
+
+	list := UserList{Size: size}
+	if len(users) == 0 {
+		list.Data = users
+		return list, nil
+	}
+
+	if cursor.Backward {
+		// backward pages are read in descending order, restore the listing order
+		for i, j := 0, len(users)-1; i < j; i, j = i+1, j-1 {
+			users[i], users[j] = users[j], users[i]
+		}
+		if more {
+			list.PrevCursor = newCursor(users[0], true).Encode()
+		}
+		if !cursor.atEnd() {
+			list.HasMore = true
+			list.NextCursor = newCursor(users[len(users)-1], false).Encode()
+		}
+	} else {
+		if more {
+			list.HasMore = true
+			list.NextCursor = newCursor(users[len(users)-1], false).Encode()
+		}
+		list.PrevCursor = newCursor(users[0], true).Encode()
+	}
+	list.Data = users
+
+	return list, nil
+}
+
+// count returns the number of users matching the name and whether the number is an estimate.
+// Exact counts fall back to the optimizer estimate when it is above pagination.exact_count_limit.
+func (r *Repository) count(name string, mode TotalMode) (int64, bool, error) {
+	limit := viper.GetInt64("pagination.exact_count_limit")
+
+	if mode == TotalEstimate || limit > 0 {
+		estimate, err := r.estimate(name)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if mode == TotalEstimate || estimate > limit {
+			return estimate, true, nil
+		}
+	}
+
+	var total int64
+	if err := r.executor().Get(&total, countUserSQL, r.tenant, name); err != nil {
+		return 0, false, err
+	}
+
+	return total, false, nil
+}
+
+// estimate reads the number of rows the optimizer expects to examine, which is cheap on large tables.
+func (r *Repository) estimate(name string) (int64, error) {
+	rows, err := r.executor().Queryx(estimateUserSQL, r.tenant, name)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var estimate int64
+	for rows.Next() {
+		row := map[string]interface{}{}
+		if err := rows.MapScan(row); err != nil {
+			return 0, err
+		}
+
+		var value int64
+		switch rowsValue := row["rows"].(type) {
+		case int64:
+			value = rowsValue
+		case []byte:
+			value, _ = strconv.ParseInt(string(rowsValue), 10, 64)
+		}
+		if value > estimate {
+			estimate = value
+		}
+	}
+
+	return estimate, rows.Err()
+}
+
+func (r *Repository) Search(query string, size int, offset int) (SearchList, error) {
+	if size <= 0 {
+		size = defaultSize
+	}
+
+	terms := searchTerms(query)
+	results := make([]SearchResult, 0)
+	if len(terms) == 0 {
+		return SearchList{Data: results, Size: size, Offset: offset}, nil
+	}
+
+	var err error
+	if r.executor().DriverName() == "mysql" && viper.GetString("search.mode") != "like" {
+		against := strings.Join(terms, "* ") + "*"
+		err = r.executor().Select(&results, searchUserSQL, against, r.tenant, against, size, offset)
+	} else {
+		// portable fallback, a name match weighs more than an address match
+		scores := make([]string, 0, len(terms))
+		args := make([]interface{}, 0, len(terms)*2+3)
+		for _, term := range terms {
+			scores = append(scores, "(CASE WHEN LOWER(name) LIKE ? ESCAPE '\\\\' THEN 2 ELSE 0 END + CASE WHEN LOWER(address) LIKE ? ESCAPE '\\\\' THEN 1 ELSE 0 END)")
+			args = append(args, "%"+escapeLike(term)+"%", "%"+escapeLike(term)+"%")
+		}
+		args = append(args, r.tenant, size, offset)
+		err = r.executor().Select(&results, fmt.Sprintf(searchUserLikeSQL, strings.Join(scores, " + ")), args...)
+	}
+	if err != nil {
+		return SearchList{}, err
+	}
+
+	for i := range results {
+		results[i].Highlights = highlights(results[i].User, terms)
+	}
+
+	return SearchList{Data: results, Size: size, Offset: offset}, nil
+}
+
+// likeEscaper escapes the backslash, the escape character of the LIKE patterns, and the wildcards.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes a value put in a LIKE pattern so that its % and _ match themselves.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
 }
 
 func (r *Repository) Delete(userId int) error {
 	//physical deletion is developed instead of logical deletion due to lack of context information
-	result, err := r.db.Exec(deleteUserSQL, userId)
+	result, err := r.executor().Exec(deleteUserSQL, r.tenant, userId)
 	if err == nil {
 		if rowsAffected, _ := result.RowsAffected(); rowsAffected < 1 {
 			return &NotFoundError{Message: fmt.Errorf(userNotFound, userId)}
@@ -108,9 +413,171 @@ func (r *Repository) Delete(userId int) error {
 	return err
 }
 
+func (r *Repository) InsertAudit(entry *AuditEntry) error {
+	entry.TenantId = r.tenant
+	_, err := r.executor().NamedExec(insertAuditSQL, entry)
+	return err
+}
+
+// History returns the audit entries of the user, newest first.
+func (r *Repository) History(userId int, size int, offset int) (History, error) {
+	if size <= 0 {
+		size = defaultSize
+	}
+
+	entries := make([]AuditEntry, 0)
+	if err := r.executor().Select(&entries, historySQL, r.tenant, userId, size, offset); err != nil {
+		return History{}, err
+	}
+
+	return History{Data: entries, Size: size, Offset: offset}, nil
+}
+
+// AuditSince returns the audit entries of the user made after the given time, newest first.
+func (r *Repository) AuditSince(userId int, since time.Time) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	err := r.executor().Select(&entries, auditSinceSQL, r.tenant, userId, since)
+
+	return entries, err
+}
+
+// FindCandidates returns the users that could be duplicates of the given one: same dob,
+// same address or a name starting like it. They still have to be scored.
+func (r *Repository) FindCandidates(user User, limit int) ([]User, error) {
+	prefix := []rune(strings.ToLower(user.Name))
+	if len(prefix) > 3 {
+		prefix = prefix[:3]
+	}
+
+	users := make([]User, 0)
+	err := r.executor().Select(&users, findCandidatesSQL, r.tenant, user.Id, user.Dob, escapeLike(string(prefix))+"%", user.Address, limit)
+
+	return users, err
+}
+
+// Merge deletes the merged users and records the survivor they were merged into. Users
+// previously merged into any of them are redirected to the survivor as well.
+func (r *Repository) Merge(survivorId int, mergedIds []int) error {
+	query, args, err := sqlx.In(repointMergeSQL, survivorId, r.tenant, mergedIds)
+	if err != nil {
+		return err
+	}
+	if _, err := r.executor().Exec(query, args...); err != nil {
+		return err
+	}
+
+	for _, mergedId := range mergedIds {
+		if _, err := r.executor().Exec(insertMergeSQL, r.tenant, mergedId, survivorId); err != nil {
+			return err
+		}
+	}
+
+	query, args, err = sqlx.In(deleteMergedSQL, r.tenant, mergedIds)
+	if err != nil {
+		return err
+	}
+	_, err = r.executor().Exec(query, args...)
+
+	return err
+}
+
+// GetSurvivor returns the user the given one was merged into, or 0 when it was not merged.
+func (r *Repository) GetSurvivor(userId int) (int, error) {
+	var survivorId int
+	err := r.executor().Get(&survivorId, getSurvivorSQL, r.tenant, userId)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return survivorId, err
+}
+
+// WithTx runs fn with a repository bound to a transaction, which is committed when fn returns
+// nil and rolled back when it returns an error or panics. Calls made on a repository that is
+// already in a transaction use a savepoint, so that only the nested work is rolled back.
+func (r *Repository) WithTx(ctx context.Context, fn func(repo IRepository) error) (err error) {
+	if r.tx != nil {
+		return r.withSavepoint(fn)
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				logrus.Errorf("error while rolling back transaction: %v", rollbackErr)
+			}
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	return fn(&Repository{db: r.db, tx: tx, tenant: r.tenant, mapApiClient: r.mapApiClient})
+}
+
+func (r *Repository) withSavepoint(fn func(repo IRepository) error) (err error) {
+	savepoint := fmt.Sprintf("sp_%d", r.depth+1)
+	if _, err := r.tx.Exec("SAVEPOINT " + savepoint); err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = r.tx.Exec("ROLLBACK TO SAVEPOINT " + savepoint)
+			panic(p)
+		}
+
+		if err != nil {
+			if _, rollbackErr := r.tx.Exec("ROLLBACK TO SAVEPOINT " + savepoint); rollbackErr != nil {
+				logrus.Errorf("error while rolling back to %s: %v", savepoint, rollbackErr)
+			}
+			return
+		}
+
+		_, err = r.tx.Exec("RELEASE SAVEPOINT " + savepoint)
+	}()
+
+	return fn(&Repository{db: r.db, tx: r.tx, depth: r.depth + 1, tenant: r.tenant, mapApiClient: r.mapApiClient})
+}
+
+// ForTenant returns the repository bound to the tenant, within the same transaction if any.
+func (r *Repository) ForTenant(tenant string) IRepository {
+	return &Repository{db: r.db, tx: r.tx, depth: r.depth, tenant: tenant, mapApiClient: r.mapApiClient}
+}
+
+func (r *Repository) executor() executor {
+	if r.tenant == "" {
+		return unscopedExecutor{}
+	}
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// unscopedExecutor fails every statement, it keeps a repository without tenant from reading
+// or writing the rows of any tenant.
+type unscopedExecutor struct{}
+
+func (unscopedExecutor) Exec(string, ...interface{}) (sql.Result, error)   { return nil, ErrNoTenant }
+func (unscopedExecutor) NamedExec(string, interface{}) (sql.Result, error) { return nil, ErrNoTenant }
+func (unscopedExecutor) Get(interface{}, string, ...interface{}) error     { return ErrNoTenant }
+func (unscopedExecutor) Select(interface{}, string, ...interface{}) error  { return ErrNoTenant }
+func (unscopedExecutor) Queryx(string, ...interface{}) (*sqlx.Rows, error) { return nil, ErrNoTenant }
+func (unscopedExecutor) DriverName() string                                { return "" }
+
 func (r *Repository) GetLocation(userId int) (Location, error) {
 	user := User{}
-	err := r.db.Get(&user, getUserDataSQL, userId)
+	err := r.executor().Get(&user, fmt.Sprintf(getUserDataSQL, selectColumns(nil)), r.tenant, userId)
 	if err != nil {
 		return Location{}, err
 	}
@@ -119,11 +586,11 @@ func (r *Repository) GetLocation(userId int) (Location, error) {
 		return Location{}, &NotFoundError{Message: fmt.Errorf(userNotFound, userId)}
 	}
 
-	path:= fmt.Sprintf(locationUrl, user.Address, viper.Get("clients.map.token"))
+	path := fmt.Sprintf(locationUrl, user.Address, infrastructure.TenantString(r.tenant, "clients.map.token"))
 	response, err := r.mapApiClient.Get(path, nil, nil)
 	defer response.Body.Close()
 
-	if !(response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices){
+	if !(response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices) {
 		return Location{}, fmt.Errorf("unexpected error")
 	}
 
@@ -138,10 +605,36 @@ func (r *Repository) GetLocation(userId int) (Location, error) {
 	return location, err
 }
 
-func NewRepository() IRepository {
+// selectColumns returns the column list of a user query. The requested fields are already
+// validated against the allow-list, required columns are always read.
+func selectColumns(fields []string, required ...string) string {
+	if len(fields) == 0 {
+		return strings.Join(userFields, ", ")
+	}
+
+	selected := make(map[string]bool, len(fields)+len(required))
+	for _, field := range fields {
+		selected[field] = true
+	}
+	for _, field := range required {
+		selected[field] = true
+	}
+
+	columns := make([]string, 0, len(selected))
+	for _, field := range userFields {
+		if selected[field] {
+			columns = append(columns, field)
+		}
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// NewRepository returns a repository on db, the pool the server shares with the other packages.
+func NewRepository(db *sqlx.DB) IRepository {
 
 	return &Repository{
-		db: infrastructure.ConnectDatabase(),
+		db:           db,
 		mapApiClient: infrastructure.NewRestClient(viper.GetString("clients.map.base_url"), time.Duration(viper.GetInt("clients.map.timeout"))),
 	}
 }
@@ -1,11 +1,21 @@
 package user
 
 import (
+	"context"
+	"time"
+
 	"github.com/stretchr/testify/mock"
 )
 
 type RepositoryMock struct {
 	mock.Mock
+	// Tenant is the tenant the mock was last bound to
+	Tenant string
+}
+
+func (m *RepositoryMock) ForTenant(tenant string) IRepository {
+	m.Tenant = tenant
+	return m
 }
 
 func (m *RepositoryMock) Insert(user *User) (int64, error) {
@@ -22,7 +32,21 @@ func (m *RepositoryMock) Update(userId int, user *User) error {
 	return args.Error(0)
 }
 
-func (m *RepositoryMock) Get(userId int) (User, error) {
+func (m *RepositoryMock) Get(userId int, fields ...string) (User, error) {
+	var args mock.Arguments
+	if len(fields) > 0 {
+		args = m.Called(userId, fields)
+	} else {
+		args = m.Called(userId)
+	}
+	err := args.Error(1)
+	if args.Get(0) == nil {
+		return User{}, err
+	}
+	return args.Get(0).(User), err
+}
+
+func (m *RepositoryMock) GetForUpdate(userId int) (User, error) {
 	args := m.Called(userId)
 	err := args.Error(1)
 	if args.Get(0) == nil {
@@ -31,8 +55,8 @@ func (m *RepositoryMock) Get(userId int) (User, error) {
 	return args.Get(0).(User), err
 }
 
-func (m *RepositoryMock) Find(name string, size int, offset int) (UserList, error) {
-	args := m.Called(name, size, offset)
+func (m *RepositoryMock) Find(filter Filter) (UserList, error) {
+	args := m.Called(filter)
 	err := args.Error(1)
 	if args.Get(0) == nil {
 		return UserList{}, err
@@ -40,11 +64,80 @@ func (m *RepositoryMock) Find(name string, size int, offset int) (UserList, erro
 	return args.Get(0).(UserList), err
 }
 
+func (m *RepositoryMock) Search(query string, size int, offset int) (SearchList, error) {
+	args := m.Called(query, size, offset)
+	err := args.Error(1)
+	if args.Get(0) == nil {
+		return SearchList{}, err
+	}
+	return args.Get(0).(SearchList), err
+}
+
 func (m *RepositoryMock) Delete(userId int) error {
 	args := m.Called(userId)
 	return args.Error(0)
 }
 
+func (m *RepositoryMock) InsertMany(users []*User) ([]int64, error) {
+	args := m.Called(users)
+	err := args.Error(1)
+	if args.Get(0) == nil {
+		return nil, err
+	}
+	return args.Get(0).([]int64), err
+}
+
+// WithTx runs fn against the mock itself, unless an error is set for the call.
+func (m *RepositoryMock) WithTx(ctx context.Context, fn func(repo IRepository) error) error {
+	args := m.Called(ctx)
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	return fn(m)
+}
+
+func (m *RepositoryMock) InsertAudit(entry *AuditEntry) error {
+	args := m.Called(entry)
+	return args.Error(0)
+}
+
+func (m *RepositoryMock) History(userId int, size int, offset int) (History, error) {
+	args := m.Called(userId, size, offset)
+	err := args.Error(1)
+	if args.Get(0) == nil {
+		return History{}, err
+	}
+	return args.Get(0).(History), err
+}
+
+func (m *RepositoryMock) AuditSince(userId int, since time.Time) ([]AuditEntry, error) {
+	args := m.Called(userId, since)
+	err := args.Error(1)
+	if args.Get(0) == nil {
+		return nil, err
+	}
+	return args.Get(0).([]AuditEntry), err
+}
+
+func (m *RepositoryMock) FindCandidates(user User, limit int) ([]User, error) {
+	args := m.Called(user, limit)
+	err := args.Error(1)
+	if args.Get(0) == nil {
+		return nil, err
+	}
+	return args.Get(0).([]User), err
+}
+
+func (m *RepositoryMock) Merge(survivorId int, mergedIds []int) error {
+	args := m.Called(survivorId, mergedIds)
+	return args.Error(0)
+}
+
+func (m *RepositoryMock) GetSurvivor(userId int) (int, error) {
+	args := m.Called(userId)
+	return args.Int(0), args.Error(1)
+}
+
 func (m *RepositoryMock) GetLocation(userId int) (Location, error) {
 	args := m.Called(userId)
 	err := args.Error(1)
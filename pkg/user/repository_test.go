@@ -1,8 +1,11 @@
 package user
 
 import (
+	"context"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/users-api/infrastructure"
+	"strings"
 	"testing"
 	"time"
 )
@@ -40,7 +43,9 @@ func TestRepository_Insert(t *testing.T) {
 			name: "Error - name too long",
 			args: args{
 				user: &User{
-					Name:    "test1test1test1test1test1test1test1test1test1test1",
+					// over the varchar(256) of the name since the test and dev schemas were
+					// merged into migration 1, the test schema had a varchar(48)
+					Name:    strings.Repeat("test1", 52),
 					Dob:     time.Now(),
 					Address: "address",
 				},
@@ -55,7 +60,7 @@ func TestRepository_Insert(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := NewRepository()
+			repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
 			userId, err := repository.Insert(tt.args.user)
 			tt.assertFunc(t, userId)
 			tt.assertError(t, err)
@@ -101,7 +106,9 @@ func TestRepository_Update(t *testing.T) {
 			args: args{
 				userId: 1,
 				user: &User{
-					Name:    "test1test1test1test1test1test1test1test1test1test1",
+					// over the varchar(256) of the name since the test and dev schemas were
+					// merged into migration 1, the test schema had a varchar(48)
+					Name:    strings.Repeat("test1", 52),
 					Dob:     time.Now(),
 					Address: "address",
 				},
@@ -116,7 +123,7 @@ func TestRepository_Update(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var userUpdated User
-			repository := NewRepository()
+			repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
 			err := repository.Update(tt.args.userId, tt.args.user)
 			if err == nil {
 				userUpdated, _ = repository.Get(tt.args.userId)
@@ -162,7 +169,7 @@ func TestRepository_Delete(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := NewRepository()
+			repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
 			err := repository.Delete(tt.args.userId)
 			tt.assertError(t, err)
 		})
@@ -209,7 +216,7 @@ func TestRepository_Get(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := NewRepository()
+			repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
 			user, err := repository.Get(tt.args.userId)
 			tt.assertError(t, err)
 			tt.assertFunc(t, user)
@@ -217,6 +224,18 @@ func TestRepository_Get(t *testing.T) {
 	}
 }
 
+func TestRepository_GetFields(t *testing.T) {
+	setTestEnvironment()
+	repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+
+	user, err := repository.Get(1, "id", "name")
+
+	assert.Nil(t, err)
+	assert.Equal(t, user.Id, 1)
+	assert.Equal(t, user.Name, "Jhon")
+	assert.Empty(t, user.Address)
+}
+
 func TestRepository_Find(t *testing.T) {
 	setTestEnvironment()
 
@@ -261,17 +280,238 @@ func TestRepository_Find(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := NewRepository()
-			user, _ := repository.Find(tt.args.name, tt.args.size, tt.args.offset)
+			repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+			user, _ := repository.Find(Filter{Name: tt.args.name, Size: tt.args.size, Offset: tt.args.offset})
 			tt.assertFunc(t, user)
 		})
 	}
 }
 
+func TestRepository_FindByCursor(t *testing.T) {
+	setTestEnvironment()
+	repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+
+	first, err := repository.Find(Filter{Name: "Jhon", Size: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, len(first.Data), 3)
+	assert.NotEmpty(t, first.NextCursor)
+	assert.Empty(t, first.PrevCursor)
+
+	next, err := DecodeCursor(first.NextCursor)
+	assert.Nil(t, err)
+
+	second, err := repository.Find(Filter{Name: "Jhon", Size: 3, Cursor: next})
+	assert.Nil(t, err)
+	assert.Equal(t, len(second.Data), 3)
+	assert.Equal(t, second.Data[0].Id, 4)
+	assert.Equal(t, second.Data[2].Id, 6)
+
+	prev, err := DecodeCursor(second.PrevCursor)
+	assert.Nil(t, err)
+
+	back, err := repository.Find(Filter{Name: "Jhon", Size: 3, Cursor: prev})
+	assert.Nil(t, err)
+	assert.Equal(t, back.Data, first.Data)
+	assert.Empty(t, back.PrevCursor)
+}
+
+// testTenant owns the users of the test fixtures.
+const testTenant = "default"
+
 func setTestEnvironment() {
 	viper.Set("env", "test")
 	viper.Set("database.host", "localhost:3305")
 	viper.Set("database.pass", "root")
 	viper.Set("database.user", "root")
 	viper.Set("database.name", "challenge")
+	viper.Set("database.fixtures", "../../cmd/config/fixtures.yml")
+}
+
+func TestRepository_FindWithTotal(t *testing.T) {
+	setTestEnvironment()
+	repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+
+	list, err := repository.Find(Filter{Name: "Jhon", Size: 5, Total: TotalExact})
+	assert.Nil(t, err)
+	assert.True(t, list.HasMore)
+	assert.Equal(t, *list.TotalCount, int64(8))
+	assert.False(t, list.TotalEstimated)
+
+	last, err := repository.Find(Filter{Name: "Jhon", Size: 5, Cursor: LastPageCursor()})
+	assert.Nil(t, err)
+	assert.False(t, last.HasMore)
+	assert.Equal(t, len(last.Data), 5)
+	assert.Equal(t, last.Data[4].Id, 8)
+}
+
+func TestRepository_Search(t *testing.T) {
+	setTestEnvironment()
+	repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+
+	results, err := repository.Search("jhon 5th", 3, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, len(results.Data), 3)
+	assert.True(t, results.Data[0].Score > 0)
+	assert.Equal(t, results.Data[0].Highlights["address"], "<em>5th</em> avenue")
+}
+
+func TestRepository_InsertMany(t *testing.T) {
+	setTestEnvironment()
+	repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+
+	user := &User{Name: "batch", Dob: time.Now(), Address: "address"}
+	ids, err := repository.InsertMany([]*User{user, user})
+
+	assert.Nil(t, err)
+	assert.Equal(t, ids, []int64{9, 10})
+
+	inserted, err := repository.Get(10)
+	assert.Nil(t, err)
+	assert.Equal(t, inserted.Name, "batch")
+}
+
+func TestRepository_WithTx(t *testing.T) {
+	setTestEnvironment()
+
+	tests := []struct {
+		name       string
+		fn         func(repo IRepository) error
+		assertFunc func(*testing.T, error, IRepository)
+	}{
+		{
+			name: "Success - commit",
+			fn: func(repo IRepository) error {
+				return repo.Delete(1)
+			},
+			assertFunc: func(t *testing.T, err error, repository IRepository) {
+				assert.Nil(t, err)
+				_, err = repository.Get(1)
+				assert.NotNil(t, err)
+			},
+		},
+		{
+			name: "Error - rollback on error",
+			fn: func(repo IRepository) error {
+				if err := repo.Delete(1); err != nil {
+					return err
+				}
+				return repo.Delete(1001)
+			},
+			assertFunc: func(t *testing.T, err error, repository IRepository) {
+				assert.NotNil(t, err)
+				_, err = repository.Get(1)
+				assert.Nil(t, err)
+			},
+		},
+		{
+			name: "Error - nested failure only rolls back to its savepoint",
+			fn: func(repo IRepository) error {
+				if err := repo.Delete(1); err != nil {
+					return err
+				}
+				_ = repo.WithTx(context.Background(), func(nested IRepository) error {
+					if err := nested.Delete(2); err != nil {
+						return err
+					}
+					return nested.Delete(1001)
+				})
+				return nil
+			},
+			assertFunc: func(t *testing.T, err error, repository IRepository) {
+				assert.Nil(t, err)
+				_, err = repository.Get(1)
+				assert.NotNil(t, err)
+				_, err = repository.Get(2)
+				assert.Nil(t, err)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+			err := repository.WithTx(context.Background(), tt.fn)
+			tt.assertFunc(t, err, repository)
+		})
+	}
+}
+
+func TestRepository_WithTxPanic(t *testing.T) {
+	setTestEnvironment()
+	repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+
+	assert.Panics(t, func() {
+		_ = repository.WithTx(context.Background(), func(repo IRepository) error {
+			_ = repo.Delete(1)
+			panic("unexpected")
+		})
+	})
+
+	_, err := repository.Get(1)
+	assert.Nil(t, err)
+}
+
+func TestRepository_Merge(t *testing.T) {
+	setTestEnvironment()
+	repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+
+	assert.Nil(t, repository.Merge(2, []int{3, 4}))
+	assert.Nil(t, repository.Merge(1, []int{2}))
+
+	_, err := repository.Get(3)
+	assert.NotNil(t, err)
+
+	survivorId, err := repository.GetSurvivor(3)
+	assert.Nil(t, err)
+	assert.Equal(t, survivorId, 1)
+
+	survivorId, err = repository.GetSurvivor(1)
+	assert.Nil(t, err)
+	assert.Zero(t, survivorId)
+}
+
+func TestRepository_History(t *testing.T) {
+	setTestEnvironment()
+	repository := NewRepository(infrastructure.ConnectDatabase()).ForTenant(testTenant)
+
+	before := "5th avenue"
+	after := "6th avenue"
+	entry := &AuditEntry{UserId: 1, Action: AuditUpdate, Actor: "admin", Changes: Changes{"address": {Before: &before, After: &after}}}
+
+	start := time.Now().Add(-time.Minute)
+	assert.Nil(t, repository.InsertAudit(entry))
+
+	history, err := repository.History(1, 10, 0)
+	assert.Nil(t, err)
+	assert.Len(t, history.Data, 1)
+	assert.Equal(t, history.Data[0].Actor, "admin")
+	assert.Equal(t, *history.Data[0].Changes["address"].After, after)
+
+	entries, err := repository.AuditSince(1, start)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestRepository_TenantIsolation(t *testing.T) {
+	setTestEnvironment()
+	unscoped := NewRepository(infrastructure.ConnectDatabase())
+	other := unscoped.ForTenant("other")
+
+	_, err := unscoped.Get(1)
+	assert.Equal(t, err, ErrNoTenant)
+
+	_, err = other.Get(1)
+	assert.IsType(t, &NotFoundError{}, err)
+
+	list, err := other.Find(Filter{Name: "Jhon"})
+	assert.Nil(t, err)
+	assert.Empty(t, list.Data)
+
+	assert.IsType(t, &NotFoundError{}, other.Delete(1))
+
+	id, err := other.Insert(&User{Name: "Jhon", Address: "5th avenue", Dob: time.Now()})
+	assert.Nil(t, err)
+
+	_, err = unscoped.ForTenant(testTenant).Get(int(id))
+	assert.IsType(t, &NotFoundError{}, err)
 }
@@ -0,0 +1,54 @@
+package user
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// searchTerms splits a search box query into lower case words, dropping the characters
+// that have a meaning in MySQL boolean full-text queries.
+func searchTerms(query string) []string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if !contains(terms, word) {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// highlights wraps the fragments of the user fields that match the search terms in <em> tags.
+// The terms are matched against the raw values, every fragment is HTML escaped afterwards so that
+// a term can't match inside an entity. Fields without matches are not returned.
+func highlights(user User, terms []string) map[string]string {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, regexp.QuoteMeta(term))
+	}
+	pattern := regexp.MustCompile("(?i)(" + strings.Join(quoted, "|") + ")")
+
+	result := make(map[string]string)
+	for field, value := range map[string]string{"name": user.Name, "address": user.Address} {
+		matches := pattern.FindAllStringIndex(value, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var highlighted strings.Builder
+		last := 0
+		for _, match := range matches {
+			highlighted.WriteString(html.EscapeString(value[last:match[0]]))
+			highlighted.WriteString("<em>" + html.EscapeString(value[match[0]:match[1]]) + "</em>")
+			last = match[1]
+		}
+		highlighted.WriteString(html.EscapeString(value[last:]))
+		result[field] = highlighted.String()
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchTerms(t *testing.T) {
+	assert.Equal(t, searchTerms(`Jhon +5th* "avenue" jhon`), []string{"jhon", "5th", "avenue"})
+	assert.Empty(t, searchTerms(" +-*~ "))
+}
+
+func TestHighlights(t *testing.T) {
+	user := User{Name: "Jhon <b>", Address: "5th avenue"}
+
+	result := highlights(user, []string{"jho", "5th"})
+
+	assert.Equal(t, result, map[string]string{
+		"name":    "<em>Jho</em>n &lt;b&gt;",
+		"address": "<em>5th</em> avenue",
+	})
+	assert.Empty(t, highlights(user, []string{"street"}))
+}
+
+func TestHighlightsEscaping(t *testing.T) {
+	user := User{Name: "Jhon & <Jane>", Address: "Smith & Sons, 5th avenue"}
+
+	// the terms must not match the entities of the escaped values
+	assert.Empty(t, highlights(user, []string{"amp", "lt"}))
+
+	result := highlights(user, []string{"&", "<jane"})
+
+	assert.Equal(t, result, map[string]string{
+		"name":    "Jhon <em>&amp;</em> <em>&lt;Jane</em>&gt;",
+		"address": "Smith <em>&amp;</em> Sons, 5th avenue",
+	})
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, escapeLike("5th avenue"), "5th avenue")
+	assert.Equal(t, escapeLike(`100% jhon_doe\`), `100\% jhon\_doe\\`)
+}
@@ -1,44 +1,562 @@
 package user
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+	"github.com/users-api/cmd/server"
+)
+
+const (
+	defaultCandidates    int    = 200
+	defaultInsertChunk   int    = 500
+	errorBatchRolledBack string = "not applied, another operation of the batch failed"
+)
+
+// errBatchFailed rolls back the transaction of a batch that has a failed operation.
+var errBatchFailed = errors.New("batch failed")
+
 type IService interface {
-	Create(user *User) (int64, error)
-	Update(userId int, user *User) error
-	Get(userId int) (User, error)
-	Find(name string, size int, offset int) (UserList, error)
-	Delete(userId int) error
-	GetLocation(userId int) (Location, error)
+	Create(ctx context.Context, user *User) (int64, error)
+	Update(ctx context.Context, userId int, user *User) error
+	Patch(ctx context.Context, userId int, apply func(user *User) error) (User, error)
+	Get(ctx context.Context, userId int, fields ...string) (User, error)
+	GetAsOf(ctx context.Context, userId int, asOf time.Time) (User, error)
+	History(ctx context.Context, userId int, size int, offset int) (History, error)
+	Find(ctx context.Context, filter Filter) (UserList, error)
+	Search(ctx context.Context, query string, size int, offset int) (SearchList, error)
+	Delete(ctx context.Context, userId int) error
+	Batch(ctx context.Context, request BatchRequest) ([]BatchResult, error)
+	Import(ctx context.Context, rows ImportReader, dryRun bool) (ImportReport, error)
+	Duplicates(ctx context.Context, userId int) (DuplicateList, error)
+	Merge(ctx context.Context, survivorId int, request MergeRequest) (User, error)
+	GetLocation(ctx context.Context, userId int) (Location, error)
 }
 
 type Service struct {
 	repository IRepository
 }
 
-func (s *Service) Create(user *User) (int64, error) {
-	return s.repository.Insert(user)
+// Create, Update, Patch and Delete write an audit entry of the change in the same transaction.
+func (s *Service) Create(ctx context.Context, user *User) (int64, error) {
+	return create(ctx, s.repo(ctx), user)
+}
+
+func (s *Service) Update(ctx context.Context, userId int, user *User) error {
+	return update(ctx, s.repo(ctx), userId, user)
+}
+
+// Patch reads the user, lets apply change it and saves the result in a single transaction.
+func (s *Service) Patch(ctx context.Context, userId int, apply func(user *User) error) (User, error) {
+	var user User
+
+	err := s.repo(ctx).WithTx(ctx, func(repo IRepository) error {
+		before, err := repo.GetForUpdate(userId)
+		if err != nil {
+			return err
+		}
+
+		user = before
+		if err := apply(&user); err != nil {
+			return err
+		}
+
+		if err := repo.Update(userId, &user); err != nil {
+			return err
+		}
+
+		return audit(repo, newAuditEntry(ctx, userId, AuditUpdate, &before, &user))
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// Get returns a MergedError for users that were merged into another one.
+func (s *Service) Get(ctx context.Context, userId int, fields ...string) (User, error) {
+	repo := s.repo(ctx)
+
+	user, err := repo.Get(userId, fields...)
+	if _, ok := err.(*NotFoundError); ok {
+		survivorId, survivorErr := repo.GetSurvivor(userId)
+		if survivorErr != nil {
+			return User{}, survivorErr
+		}
+		if survivorId != 0 {
+			return User{}, &MergedError{UserId: userId, SurvivorId: survivorId}
+		}
+	}
+
+	return user, err
+}
+
+// GetAsOf rebuilds the user as it was at the given time by undoing the audited changes made
+// after it. Users that did not exist at that time are not found.
+func (s *Service) GetAsOf(ctx context.Context, userId int, asOf time.Time) (User, error) {
+	var current *User
+	repo := s.repo(ctx)
+
+	user, err := repo.Get(userId)
+	switch err.(type) {
+	case nil:
+		current = &user
+	case *NotFoundError:
+	default:
+		return User{}, err
+	}
+
+	entries, err := repo.AuditSince(userId, asOf)
+	if err != nil {
+		return User{}, err
+	}
+
+	state := rewind(userId, current, entries)
+	if state == nil {
+		return User{}, &NotFoundError{Message: fmt.Errorf(userNotFoundAt, userId, asOf.Format(time.RFC3339))}
+	}
+
+	return *state, nil
+}
+
+func (s *Service) History(ctx context.Context, userId int, size int, offset int) (History, error) {
+	return s.repo(ctx).History(userId, size, offset)
+}
+
+func (s *Service) Find(ctx context.Context, filter Filter) (UserList, error) {
+	return s.repo(ctx).Find(filter)
+}
+
+func (s *Service) Search(ctx context.Context, query string, size int, offset int) (SearchList, error) {
+	return s.repo(ctx).Search(query, size, offset)
 }
 
-func (s *Service) Update(userId int, user *User) error {
-	return s.repository.Update(userId, user)
+func (s *Service) Delete(ctx context.Context, userId int) error {
+	return remove(ctx, s.repo(ctx), userId)
 }
 
-func (s *Service) Get(userId int) (User, error) {
-	return s.repository.Get(userId)
+func create(ctx context.Context, repo IRepository, user *User) (int64, error) {
+	var id int64
+
+	err := repo.WithTx(ctx, func(tx IRepository) error {
+		var err error
+		if id, err = tx.Insert(user); err != nil {
+			return err
+		}
+
+		return audit(tx, newAuditEntry(ctx, int(id), AuditCreate, nil, user))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
 }
 
-func (s *Service) Find(name string, size int, offset int) (UserList, error) {
-	return s.repository.Find(name, size, offset)
+func update(ctx context.Context, repo IRepository, userId int, user *User) error {
+	return repo.WithTx(ctx, func(tx IRepository) error {
+		before, err := tx.GetForUpdate(userId)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Update(userId, user); err != nil {
+			return err
+		}
+
+		return audit(tx, newAuditEntry(ctx, userId, AuditUpdate, &before, user))
+	})
 }
 
-func (s *Service) Delete(userId int) error {
-	return s.repository.Delete(userId)
+func remove(ctx context.Context, repo IRepository, userId int) error {
+	return repo.WithTx(ctx, func(tx IRepository) error {
+		before, err := tx.GetForUpdate(userId)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(userId); err != nil {
+			return err
+		}
+
+		return audit(tx, newAuditEntry(ctx, userId, AuditDelete, &before, nil))
+	})
 }
 
-func (s *Service) GetLocation(userId int) (Location, error) {
-	return s.repository.GetLocation(userId)
+// audit writes the entry, updates that did not change any audited field are skipped.
+func audit(repo IRepository, entry *AuditEntry) error {
+	if len(entry.Changes) == 0 {
+		return nil
+	}
+	return repo.InsertAudit(entry)
+}
+
+// Batch validates every operation before applying the valid ones. In transaction mode a single
+// invalid or failed operation prevents the whole batch from being applied.
+func (s *Service) Batch(ctx context.Context, request BatchRequest) ([]BatchResult, error) {
+	results := make([]BatchResult, len(request.Operations))
+	valid := make([]BatchOperation, 0, len(request.Operations))
+
+	for i, operation := range request.Operations {
+		operation.Index = i
+		results[i] = BatchResult{Index: i, Op: operation.Op, Id: int64(operation.Id)}
+
+		if err := operation.Validate(); err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Errors = []string{err.Error()}
+			continue
+		}
+		valid = append(valid, operation)
+	}
+
+	var executed []BatchResult
+	if request.Mode == BatchModeBestEffort {
+		executed = executeBatch(ctx, s.repo(ctx), valid, false)
+	} else {
+		if len(valid) < len(request.Operations) {
+			return rolledBack(results), nil
+		}
+
+		err := s.repo(ctx).WithTx(ctx, func(repo IRepository) error {
+			executed = executeBatch(ctx, repo, valid, true)
+			if failed(executed) {
+				return errBatchFailed
+			}
+			return nil
+		})
+		if err == errBatchFailed {
+			executed = rolledBack(executed)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, result := range executed {
+		results[result.Index] = result
+	}
+
+	return results, nil
+}
+
+// executeBatch applies the operations in order, each one in its own transaction or savepoint.
+// Consecutive creates are grouped in multi-row inserts of batch.insert_chunk rows. When
+// stopOnError is set the operations after the first failure are returned without status.
+func executeBatch(ctx context.Context, repo IRepository, operations []BatchOperation, stopOnError bool) []BatchResult {
+	chunk := insertChunk()
+
+	results := make([]BatchResult, 0, len(operations))
+	for start := 0; start < len(operations); {
+		end := start + 1
+		if operations[start].Op == BatchCreate {
+			for end < len(operations) && end-start < chunk && operations[end].Op == BatchCreate {
+				end++
+			}
+			results = append(results, createBatch(ctx, repo, operations[start:end])...)
+		} else {
+			results = append(results, applyBatch(ctx, repo, operations[start]))
+		}
+		start = end
+
+		if stopOnError && failed(results) {
+			for _, operation := range operations[start:] {
+				results = append(results, BatchResult{Index: operation.Index, Op: operation.Op, Id: int64(operation.Id)})
+			}
+			break
+		}
+	}
+
+	return results
+}
+
+// createBatch inserts the users of a group of creates, falling back to one insert per user
+// when the multi-row insert fails so that the failing rows can be reported.
+func createBatch(ctx context.Context, repo IRepository, operations []BatchOperation) []BatchResult {
+	users := make([]*User, 0, len(operations))
+	for _, operation := range operations {
+		users = append(users, operation.User.User())
+	}
+
+	results := make([]BatchResult, 0, len(operations))
+
+	var ids []int64
+	err := repo.WithTx(ctx, func(tx IRepository) error {
+		var err error
+		if ids, err = tx.InsertMany(users); err != nil {
+			return err
+		}
+
+		for i, user := range users {
+			if err := audit(tx, newAuditEntry(ctx, int(ids[i]), AuditCreate, nil, user)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		for i, operation := range operations {
+			results = append(results, BatchResult{Index: operation.Index, Op: operation.Op, Id: ids[i], Status: http.StatusCreated})
+		}
+		return results
+	}
+
+	for _, operation := range operations {
+		result := BatchResult{Index: operation.Index, Op: operation.Op, Status: http.StatusCreated}
+		if result.Id, err = create(ctx, repo, operation.User.User()); err != nil {
+			result.Status = http.StatusInternalServerError
+			result.Errors = []string{err.Error()}
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func applyBatch(ctx context.Context, repo IRepository, operation BatchOperation) BatchResult {
+	result := BatchResult{Index: operation.Index, Op: operation.Op, Id: int64(operation.Id), Status: http.StatusOK}
+
+	var err error
+	if operation.Op == BatchDelete {
+		err = remove(ctx, repo, operation.Id)
+	} else {
+		err = update(ctx, repo, operation.Id, operation.User.User())
+	}
+
+	switch err.(type) {
+	case nil:
+	case *NotFoundError:
+		result.Status = http.StatusNotFound
+		result.Errors = []string{err.Error()}
+	default:
+		result.Status = http.StatusInternalServerError
+		result.Errors = []string{err.Error()}
+	}
+
+	return result
+}
+
+func failed(results []BatchResult) bool {
+	for _, result := range results {
+		if result.Status >= http.StatusBadRequest {
+			return true
+		}
+	}
+	return false
+}
+
+// rolledBack marks the operations that did not fail themselves as not applied.
+func rolledBack(results []BatchResult) []BatchResult {
+	for i := range results {
+		if results[i].Status < http.StatusBadRequest {
+			if results[i].Op == BatchCreate {
+				results[i].Id = 0
+			}
+			results[i].Status = http.StatusFailedDependency
+			results[i].Errors = []string{errorBatchRolledBack}
+		}
+	}
+	return results
+}
+
+// Import validates the rows as they are read and inserts the valid ones in chunks of
+// batch.insert_chunk users, each chunk in its own savepoint, so only a chunk is held in memory.
+// A dry run validates the rows without inserting them. The import runs in a single transaction:
+// an error reading the file or a timeout rolls it back, so a failed import inserted nothing and
+// the file can be sent again.
+func (s *Service) Import(ctx context.Context, rows ImportReader, dryRun bool) (ImportReport, error) {
+	if dryRun {
+		return importRows(ctx, s.repo(ctx), rows, true)
+	}
+
+	var report ImportReport
+	err := s.repo(ctx).WithTx(ctx, func(repo IRepository) error {
+		var err error
+		report, err = importRows(ctx, repo, rows, false)
+		return err
+	})
+	return report, err
+}
+
+func importRows(ctx context.Context, repo IRepository, rows ImportReader, dryRun bool) (ImportReport, error) {
+	chunk := insertChunk()
+	maxErrors := viper.GetInt("import.max_errors")
+	if maxErrors < 1 {
+		maxErrors = defaultImportMaxErrors
+	}
+
+	report := ImportReport{DryRun: dryRun, Errors: []ImportError{}}
+	lines := make([]int, 0, chunk)
+	operations := make([]BatchOperation, 0, chunk)
+
+	flush := func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		accepted := len(operations)
+		if !dryRun && len(operations) > 0 {
+			for _, result := range createBatch(ctx, repo, operations) {
+				if result.Status >= http.StatusBadRequest {
+					accepted--
+					report.reject(lines[result.Index], result.Errors, maxErrors)
+				}
+			}
+		}
+		report.Accepted += accepted
+		lines, operations = lines[:0], operations[:0]
+		return nil
+	}
+
+	for {
+		row, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, &ImportReadError{Err: err}
+		}
+		report.Rows++
+
+		if len(row.Errors) == 0 {
+			if err := row.User.User().Validate(); err != nil {
+				row.Errors = []string{err.Error()}
+			}
+		}
+		if len(row.Errors) > 0 {
+			report.reject(row.Line, row.Errors, maxErrors)
+			continue
+		}
+
+		operations = append(operations, BatchOperation{Index: len(operations), Op: BatchCreate, User: row.User})
+		lines = append(lines, row.Line)
+		if len(operations) == chunk {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, flush()
+}
+
+func insertChunk() int {
+	chunk := viper.GetInt("batch.insert_chunk")
+	if chunk < 1 {
+		return defaultInsertChunk
+	}
+	return chunk
+}
+
+// Duplicates returns the users that are likely the same person, best match first.
+func (s *Service) Duplicates(ctx context.Context, userId int) (DuplicateList, error) {
+	repo := s.repo(ctx)
+
+	user, err := repo.Get(userId)
+	if err != nil {
+		return DuplicateList{}, err
+	}
+
+	limit := viper.GetInt("duplicates.candidates")
+	if limit < 1 {
+		limit = defaultCandidates
+	}
+
+	candidates, err := repo.FindCandidates(user, limit)
+	if err != nil {
+		return DuplicateList{}, err
+	}
+
+	return DuplicateList{Data: rankDuplicates(user, candidates)}, nil
+}
+
+// Merge combines the users into the survivor following the field precedence, then deletes
+// them. Getting a merged user afterwards returns a MergedError pointing to the survivor.
+func (s *Service) Merge(ctx context.Context, survivorId int, request MergeRequest) (User, error) {
+	if len(request.Ids) == 0 {
+		return User{}, &ValidationError{Message: errors.New("ids is mandatory")}
+	}
+	seen := make(map[int]bool, len(request.Ids))
+	for _, id := range request.Ids {
+		if id == survivorId {
+			return User{}, &ValidationError{Message: errors.New("the survivor can't be merged into itself")}
+		}
+		if seen[id] {
+			return User{}, &ValidationError{Message: fmt.Errorf("the user %d is listed more than once", id)}
+		}
+		seen[id] = true
+	}
+
+	precedence, err := precedences(request.Precedence)
+	if err != nil {
+		return User{}, &ValidationError{Message: err}
+	}
+
+	var survivor User
+	err = s.repo(ctx).WithTx(ctx, func(repo IRepository) error {
+		before, err := repo.GetForUpdate(survivorId)
+		if err != nil {
+			return err
+		}
+
+		merged := make([]User, 0, len(request.Ids))
+		for _, id := range request.Ids {
+			user, err := repo.GetForUpdate(id)
+			if err != nil {
+				return err
+			}
+			merged = append(merged, user)
+		}
+
+		survivor = mergeUsers(before, merged, precedence)
+		if err := repo.Update(survivorId, &survivor); err != nil {
+			return err
+		}
+		if err := audit(repo, newAuditEntry(ctx, survivorId, AuditUpdate, &before, &survivor)); err != nil {
+			return err
+		}
+
+		if err := repo.Merge(survivorId, request.Ids); err != nil {
+			return err
+		}
+
+		for i := range merged {
+			if err := audit(repo, newAuditEntry(ctx, merged[i].Id, AuditDelete, &merged[i], nil)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return survivor, nil
+}
+
+func (s *Service) GetLocation(ctx context.Context, userId int) (Location, error) {
+	return s.repo(ctx).GetLocation(userId)
+}
+
+// repo returns the repository bound to the tenant of the request.
+func (s *Service) repo(ctx context.Context) IRepository {
+	return s.repository.ForTenant(server.GetTenant(ctx))
+}
+
+func NewService(db *sqlx.DB) IService {
+	return &Service{
+		repository: NewRepository(db),
+	}
 }
 
-func NewService() IService {
+// NewServiceWithRepository returns a service on the given repository, e.g. a RepositoryMock
+// in the tests of other packages.
+func NewServiceWithRepository(repository IRepository) IService {
 	return &Service{
-		repository: NewRepository(),
+		repository: repository,
 	}
 }
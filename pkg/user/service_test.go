@@ -1,8 +1,14 @@
 package user
 
 import (
+	"context"
 	"errors"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/users-api/cmd/server"
+	"io"
+	"strings"
 	"testing"
 	"time"
 )
@@ -31,8 +37,12 @@ func TestService_Create(t *testing.T) {
 		{
 			name: "Success - repository response ok",
 			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Once()
 				repositoryMock.On("Insert", user).
 					Return(int64(1), nil).Once()
+				repositoryMock.On("InsertAudit", mock.MatchedBy(func(entry *AuditEntry) bool {
+					return entry.UserId == 1 && entry.Action == AuditCreate
+				})).Return(nil).Once()
 			},
 			args: args{
 				user: user,
@@ -50,6 +60,7 @@ func TestService_Create(t *testing.T) {
 		{
 			name: "Error - repository response err",
 			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Once()
 				repositoryMock.On("Insert", user).
 					Return(int64(0), errors.New("some error")).Once()
 			},
@@ -74,7 +85,7 @@ func TestService_Create(t *testing.T) {
 				repository: repositoryMock,
 			}
 
-			userId, err := service.Create(tt.args.user)
+			userId, err := service.Create(context.Background(), tt.args.user)
 			tt.assertMocks(t)
 			tt.assertError(t, err)
 			tt.assertFunc(t, userId)
@@ -152,7 +163,7 @@ func TestService_Get(t *testing.T) {
 				repository: repositoryMock,
 			}
 
-			userId, err := service.Get(tt.args.userId)
+			userId, err := service.Get(context.Background(), tt.args.userId)
 			tt.assertMocks(t)
 			tt.assertError(t, err)
 			tt.assertFunc(t, userId)
@@ -181,7 +192,7 @@ func TestService_Find(t *testing.T) {
 		{
 			name: "Success - repository response ok",
 			initMocks: func() {
-				repositoryMock.On("Find", "Jhon", 1, 2).
+				repositoryMock.On("Find", Filter{Name: "Jhon", Size: 1, Offset: 2}).
 					Return(UserList{Data: make([]User, 5)}, nil).Once()
 			},
 			args: args{
@@ -202,7 +213,7 @@ func TestService_Find(t *testing.T) {
 		{
 			name: "Error - repository response err",
 			initMocks: func() {
-				repositoryMock.On("Find", "Jhon", 1, 2).
+				repositoryMock.On("Find", Filter{Name: "Jhon", Size: 1, Offset: 2}).
 					Return(UserList{}, errors.New("some error")).Once()
 			},
 			args: args{
@@ -228,10 +239,520 @@ func TestService_Find(t *testing.T) {
 				repository: repositoryMock,
 			}
 
-			userId, err := service.Find(tt.args.name, tt.args.size, tt.args.offset)
+			userId, err := service.Find(context.Background(), Filter{Name: tt.args.name, Size: tt.args.size, Offset: tt.args.offset})
 			tt.assertMocks(t)
 			tt.assertError(t, err)
 			tt.assertFunc(t, userId)
 		})
 	}
 }
+
+func TestService_Search(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+
+	tests := []struct {
+		name        string
+		initMocks   func()
+		assertError func(*testing.T, error)
+		assertFunc  func(*testing.T, SearchList)
+	}{
+		{
+			name: "Success - repository response ok",
+			initMocks: func() {
+				repositoryMock.On("Search", "jhon 5th", 10, 0).
+					Return(SearchList{Data: []SearchResult{{User: User{Id: 1}, Score: 1.5}}}, nil).Once()
+			},
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, results SearchList) {
+				assert.Equal(t, len(results.Data), 1)
+				assert.Equal(t, results.Data[0].Score, 1.5)
+			},
+		},
+		{
+			name: "Error - repository response err",
+			initMocks: func() {
+				repositoryMock.On("Search", "jhon 5th", 10, 0).
+					Return(SearchList{}, errors.New("some error")).Once()
+			},
+			assertError: func(t *testing.T, e error) {
+				assert.NotNil(t, e)
+			},
+			assertFunc: func(t *testing.T, results SearchList) {
+				assert.Equal(t, len(results.Data), 0)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.initMocks()
+			service := Service{
+				repository: repositoryMock,
+			}
+
+			results, err := service.Search(context.Background(), "jhon 5th", 10, 0)
+			repositoryMock.AssertExpectations(t)
+			tt.assertError(t, err)
+			tt.assertFunc(t, results)
+		})
+	}
+}
+
+func TestService_Batch(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+	userRequest := &UserRequest{Name: "Jhon", Address: "5th avenue", Dob: time.Now()}
+	user := userRequest.User()
+
+	tests := []struct {
+		name        string
+		initMocks   func()
+		request     BatchRequest
+		assertError func(*testing.T, error)
+		assertFunc  func(*testing.T, []BatchResult)
+	}{
+		{
+			name: "Success - valid operations are executed in a transaction",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Times(3)
+				repositoryMock.On("InsertMany", []*User{user, user}).Return([]int64{9, 10}, nil).Once()
+				repositoryMock.On("GetForUpdate", 2).Return(*user, nil).Once()
+				repositoryMock.On("Delete", 2).Return(nil).Once()
+				repositoryMock.On("InsertAudit", mock.Anything).Return(nil).Times(3)
+			},
+			request: BatchRequest{Operations: []BatchOperation{
+				{Op: BatchCreate, User: userRequest},
+				{Op: BatchCreate, User: userRequest},
+				{Op: BatchDelete, Id: 2},
+			}},
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, results []BatchResult) {
+				assert.Equal(t, results[0].Id, int64(9))
+				assert.Equal(t, results[1].Id, int64(10))
+				assert.Equal(t, results[2].Status, 200)
+			},
+		},
+		{
+			name:      "Success - invalid operation cancels a transaction",
+			initMocks: func() {},
+			request: BatchRequest{Operations: []BatchOperation{
+				{Op: BatchCreate, User: userRequest},
+				{Op: BatchUpdate, User: userRequest},
+			}},
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, results []BatchResult) {
+				assert.Equal(t, results[0].Status, 424)
+				assert.Equal(t, results[1].Status, 400)
+			},
+		},
+		{
+			name: "Success - failed operation rolls back a transaction",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Times(3)
+				repositoryMock.On("GetForUpdate", 2).Return(*user, nil).Once()
+				repositoryMock.On("Delete", 2).Return(nil).Once()
+				repositoryMock.On("InsertAudit", mock.Anything).Return(nil).Once()
+				repositoryMock.On("GetForUpdate", 1001).Return(User{}, &NotFoundError{Message: errors.New("not found")}).Once()
+			},
+			request: BatchRequest{Operations: []BatchOperation{
+				{Op: BatchDelete, Id: 2},
+				{Op: BatchDelete, Id: 1001},
+				{Op: BatchDelete, Id: 3},
+			}},
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, results []BatchResult) {
+				assert.Equal(t, results[0].Status, 424)
+				assert.Equal(t, results[1].Status, 404)
+				assert.Equal(t, results[2].Status, 424)
+			},
+		},
+		{
+			name: "Success - invalid operation is skipped in best effort",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Once()
+				repositoryMock.On("GetForUpdate", 2).Return(User{}, &NotFoundError{Message: errors.New("not found")}).Once()
+			},
+			request: BatchRequest{Mode: BatchModeBestEffort, Operations: []BatchOperation{
+				{Op: "upsert"},
+				{Op: BatchDelete, Id: 2},
+			}},
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, results []BatchResult) {
+				assert.Equal(t, results[0].Status, 400)
+				assert.Equal(t, results[1].Status, 404)
+			},
+		},
+		{
+			name: "Error - transaction can't be started",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(errors.New("some error")).Once()
+			},
+			request: BatchRequest{Operations: []BatchOperation{{Op: BatchDelete, Id: 2}}},
+			assertError: func(t *testing.T, e error) {
+				assert.NotNil(t, e)
+			},
+			assertFunc: func(t *testing.T, results []BatchResult) {
+				assert.Nil(t, results)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.initMocks()
+			service := Service{
+				repository: repositoryMock,
+			}
+
+			results, err := service.Batch(context.Background(), tt.request)
+			repositoryMock.AssertExpectations(t)
+			tt.assertError(t, err)
+			tt.assertFunc(t, results)
+		})
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestService_Import(t *testing.T) {
+
+	viper.Set("batch.insert_chunk", 2)
+	viper.Set("import.max_errors", 1)
+	defer viper.Set("batch.insert_chunk", 0)
+	defer viper.Set("import.max_errors", 0)
+
+	repositoryMock := &RepositoryMock{}
+	jhon, jane, jim := &User{Name: "Jhon"}, &User{Name: "Jane"}, &User{Name: "Jim"}
+	file := "{\"name\":\"Jhon\"}\n{\"name\":\"Jo\"}\n{\"name\":\"Jane\"}\n{\"name\":\"Jim\"}\n"
+
+	tests := []struct {
+		name        string
+		initMocks   func()
+		file        io.Reader
+		dryRun      bool
+		assertError func(*testing.T, error)
+		assertFunc  func(*testing.T, ImportReport)
+	}{
+		{
+			name: "Success - valid rows are inserted in chunks",
+			initMocks: func() {
+				// the import and each chunk
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Times(3)
+				repositoryMock.On("InsertMany", []*User{jhon, jane}).Return([]int64{1, 2}, nil).Once()
+				repositoryMock.On("InsertMany", []*User{jim}).Return([]int64{3}, nil).Once()
+				repositoryMock.On("InsertAudit", mock.Anything).Return(nil).Times(3)
+			},
+			file: strings.NewReader(file),
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, report ImportReport) {
+				assert.Equal(t, report.Rows, 4)
+				assert.Equal(t, report.Accepted, 3)
+				assert.Equal(t, report.Rejected, 1)
+				assert.Equal(t, report.Errors[0].Line, 2)
+				assert.Contains(t, report.Errors[0].Errors[0], "'Name' failed on the 'min' tag")
+			},
+		},
+		{
+			name:      "Success - dry run validates without inserting",
+			initMocks: func() {},
+			file:      strings.NewReader(file),
+			dryRun:    true,
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, report ImportReport) {
+				assert.Equal(t, report.DryRun, true)
+				assert.Equal(t, report.Accepted, 3)
+				assert.Equal(t, report.Rejected, 1)
+			},
+		},
+		{
+			name: "Success - rows that fail to insert are rejected",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Times(4)
+				repositoryMock.On("InsertMany", []*User{jhon, jane}).Return(nil, errors.New("Data too long")).Once()
+				repositoryMock.On("Insert", jhon).Return(int64(1), nil).Once()
+				repositoryMock.On("Insert", jane).Return(nil, errors.New("Data too long")).Once()
+				repositoryMock.On("InsertAudit", mock.Anything).Return(nil).Once()
+			},
+			file: strings.NewReader("{\"name\":\"Jhon\"}\n{\"name\":\"Jane\"}\n{\"name\":\"Jo\"}\n"),
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, report ImportReport) {
+				assert.Equal(t, report.Accepted, 1)
+				assert.Equal(t, report.Rejected, 2)
+				assert.Equal(t, report.Errors, []ImportError{{Line: 2, Errors: []string{"Data too long"}}})
+				assert.Equal(t, report.ErrorsTruncated, true)
+			},
+		},
+		{
+			name: "Error - file can't be read",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Once()
+			},
+			file: failingReader{},
+			assertError: func(t *testing.T, e error) {
+				assert.IsType(t, &ImportReadError{}, e)
+				assert.EqualError(t, e, "connection reset")
+			},
+			assertFunc: func(t *testing.T, report ImportReport) {
+				assert.Equal(t, report.Rows, 0)
+			},
+		},
+		{
+			name: "Error - file cut after a chunk fails the transaction of the import",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Twice()
+				repositoryMock.On("InsertMany", []*User{jhon, jane}).Return([]int64{1, 2}, nil).Once()
+				repositoryMock.On("InsertAudit", mock.Anything).Return(nil).Twice()
+			},
+			file: io.MultiReader(strings.NewReader("{\"name\":\"Jhon\"}\n{\"name\":\"Jane\"}\n"), failingReader{}),
+			assertError: func(t *testing.T, e error) {
+				assert.EqualError(t, e, "connection reset")
+			},
+			assertFunc: func(t *testing.T, report ImportReport) {
+				assert.Equal(t, report.Rows, 2)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.initMocks()
+			service := Service{
+				repository: repositoryMock,
+			}
+
+			report, err := service.Import(context.Background(), NewNDJSONReader(tt.file), tt.dryRun)
+			repositoryMock.AssertExpectations(t)
+			tt.assertError(t, err)
+			tt.assertFunc(t, report)
+		})
+	}
+}
+
+func TestService_Patch(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+
+	tests := []struct {
+		name        string
+		initMocks   func()
+		apply       func(user *User) error
+		assertError func(*testing.T, error)
+		assertFunc  func(*testing.T, User)
+	}{
+		{
+			name: "Success - patched user is saved",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Once()
+				repositoryMock.On("GetForUpdate", 1).Return(User{Id: 1, Name: "Jhon", Address: "5th avenue"}, nil).Once()
+				repositoryMock.On("Update", 1, &User{Id: 1, Name: "Jhon", Address: "6th avenue"}).Return(nil).Once()
+				repositoryMock.On("InsertAudit", mock.MatchedBy(func(entry *AuditEntry) bool {
+					return entry.Action == AuditUpdate && len(entry.Changes) == 1 && *entry.Changes["address"].After == "6th avenue"
+				})).Return(nil).Once()
+			},
+			apply: func(user *User) error {
+				user.Address = "6th avenue"
+				return nil
+			},
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, user User) {
+				assert.Equal(t, user.Address, "6th avenue")
+			},
+		},
+		{
+			name: "Error - invalid patch is not saved",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Once()
+				repositoryMock.On("GetForUpdate", 1).Return(User{Id: 1, Name: "Jhon"}, nil).Once()
+			},
+			apply: func(user *User) error {
+				return &ValidationError{Message: errors.New("invalid")}
+			},
+			assertError: func(t *testing.T, e error) {
+				assert.IsType(t, &ValidationError{}, e)
+			},
+			assertFunc: func(t *testing.T, user User) {
+				assert.Equal(t, user, User{})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.initMocks()
+			service := Service{
+				repository: repositoryMock,
+			}
+
+			user, err := service.Patch(context.Background(), 1, tt.apply)
+			repositoryMock.AssertExpectations(t)
+			tt.assertError(t, err)
+			tt.assertFunc(t, user)
+		})
+	}
+}
+
+func TestService_GetMerged(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+	repositoryMock.On("Get", 5).Return(User{}, &NotFoundError{Message: errors.New("not found")}).Once()
+	repositoryMock.On("GetSurvivor", 5).Return(1, nil).Once()
+
+	service := Service{
+		repository: repositoryMock,
+	}
+
+	_, err := service.Get(context.Background(), 5)
+
+	repositoryMock.AssertExpectations(t)
+	assert.Equal(t, err, &MergedError{UserId: 5, SurvivorId: 1})
+}
+
+func TestService_Tenant(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+	repositoryMock.On("Get", 1).Return(User{Id: 1}, nil).Once()
+
+	service := Service{
+		repository: repositoryMock,
+	}
+
+	_, err := service.Get(server.WithTenant(context.Background(), "acme"), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, repositoryMock.Tenant, "acme")
+}
+
+func TestService_GetAsOf(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+	before := "5th avenue"
+	after := "6th avenue"
+	asOf := time.Now().Add(-time.Hour)
+
+	repositoryMock.On("Get", 1).Return(User{Id: 1, Name: "Jhon", Address: after}, nil).Once()
+	repositoryMock.On("AuditSince", 1, asOf).Return([]AuditEntry{
+		{Action: AuditUpdate, Changes: Changes{"address": {Before: &before, After: &after}}},
+	}, nil).Once()
+	repositoryMock.On("Get", 2).Return(User{}, &NotFoundError{Message: errors.New("not found")}).Once()
+	repositoryMock.On("AuditSince", 2, asOf).Return([]AuditEntry{}, nil).Once()
+
+	service := Service{
+		repository: repositoryMock,
+	}
+
+	user, err := service.GetAsOf(context.Background(), 1, asOf)
+	assert.Nil(t, err)
+	assert.Equal(t, user.Address, before)
+
+	_, err = service.GetAsOf(context.Background(), 2, asOf)
+	assert.IsType(t, &NotFoundError{}, err)
+
+	repositoryMock.AssertExpectations(t)
+}
+
+func TestService_Merge(t *testing.T) {
+
+	repositoryMock := &RepositoryMock{}
+	survivor := User{Id: 1, Name: "Jhon", Address: "5th avenue"}
+	merged := User{Id: 2, Name: "Jhon", Address: "5th avenue, NY"}
+
+	tests := []struct {
+		name        string
+		initMocks   func()
+		request     MergeRequest
+		assertError func(*testing.T, error)
+		assertFunc  func(*testing.T, User)
+	}{
+		{
+			name: "Success - users are merged into the survivor",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Once()
+				repositoryMock.On("GetForUpdate", 1).Return(survivor, nil).Once()
+				repositoryMock.On("GetForUpdate", 2).Return(merged, nil).Once()
+				repositoryMock.On("Update", 1, &User{Id: 1, Name: "Jhon", Address: "5th avenue, NY"}).Return(nil).Once()
+				repositoryMock.On("Merge", 1, []int{2}).Return(nil).Once()
+				repositoryMock.On("InsertAudit", mock.MatchedBy(func(entry *AuditEntry) bool {
+					return entry.UserId == 1 && entry.Action == AuditUpdate
+				})).Return(nil).Once()
+				repositoryMock.On("InsertAudit", mock.MatchedBy(func(entry *AuditEntry) bool {
+					return entry.UserId == 2 && entry.Action == AuditDelete
+				})).Return(nil).Once()
+			},
+			request: MergeRequest{Ids: []int{2}, Precedence: map[string]string{"address": PrecedenceLongest}},
+			assertError: func(t *testing.T, e error) {
+				assert.Nil(t, e)
+			},
+			assertFunc: func(t *testing.T, user User) {
+				assert.Equal(t, user.Address, "5th avenue, NY")
+			},
+		},
+		{
+			name:      "Error - duplicate ids",
+			initMocks: func() {},
+			request:   MergeRequest{Ids: []int{5, 5}},
+			assertError: func(t *testing.T, e error) {
+				assert.EqualError(t, e, "the user 5 is listed more than once")
+				assert.IsType(t, &ValidationError{}, e)
+			},
+			assertFunc: func(t *testing.T, user User) {},
+		},
+		{
+			name:      "Error - survivor in ids",
+			initMocks: func() {},
+			request:   MergeRequest{Ids: []int{1}},
+			assertError: func(t *testing.T, e error) {
+				assert.IsType(t, &ValidationError{}, e)
+			},
+			assertFunc: func(t *testing.T, user User) {
+				assert.Equal(t, user, User{})
+			},
+		},
+		{
+			name: "Error - merged user not found",
+			initMocks: func() {
+				repositoryMock.On("WithTx", mock.Anything).Return(nil).Once()
+				repositoryMock.On("GetForUpdate", 1).Return(survivor, nil).Once()
+				repositoryMock.On("GetForUpdate", 3).Return(User{}, &NotFoundError{Message: errors.New("not found")}).Once()
+			},
+			request: MergeRequest{Ids: []int{3}},
+			assertError: func(t *testing.T, e error) {
+				assert.IsType(t, &NotFoundError{}, e)
+			},
+			assertFunc: func(t *testing.T, user User) {
+				assert.Equal(t, user, User{})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.initMocks()
+			service := Service{
+				repository: repositoryMock,
+			}
+
+			user, err := service.Merge(context.Background(), 1, tt.request)
+			repositoryMock.AssertExpectations(t)
+			tt.assertError(t, err)
+			tt.assertFunc(t, user)
+		})
+	}
+}